@@ -0,0 +1,460 @@
+package dkim
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// AuthenticationResults returns an Authentication-Results header field, as
+// described in RFC 8601, reporting results from verifying a message. It
+// has a dkim method result for each signature in results, along with the
+// header.d, header.s, header.i and header.b (RFC 6008) properties of the
+// signature and the reason that it failed. If there are no results, the
+// message was unsigned and the dkim result is "none".
+//
+// err is the error returned along with results by VerifyAll. If it's set
+// to anything other than ErrNoSignature, the message couldn't be
+// verified at all, and instead of results the header has a single dkim
+// result of "temperror" or "permerror" with err as the reason.
+//
+// Signatures made with a key in testing mode have a result of "neutral"
+// whether or not they verified, since RFC 6376 section 3.6.1 says they
+// must be treated the same as unsigned messages.
+//
+// authservID identifies the server which verified the message, and is
+// usually its host name.
+//
+// The header field is folded onto multiple lines with CRLF line endings,
+// but does not end with a line ending.
+func AuthenticationResults(authservID string, results []Result, err error) string {
+	ret := "Authentication-Results: " + authservID
+	if err != nil && !errors.Is(err, ErrNoSignature) {
+		status := "permerror"
+		if errors.Is(err, ErrTempFail) {
+			status = "temperror"
+		}
+		return ret + "; dkim=" + status + " reason=" + quoteValue(errorReason(err))
+	}
+	if len(results) == 0 {
+		return ret + "; dkim=none"
+	}
+	for _, res := range results {
+		status, reason := res.Status.String(), ""
+		if res.Err != nil {
+			reason = errorReason(res.Err)
+		}
+		if res.Testing {
+			status = "neutral"
+			if reason == "" {
+				reason = "key in testing mode"
+			} else {
+				reason = "key in testing mode: " + reason
+			}
+		}
+		ret += ";\r\n\tdkim=" + status
+		if reason != "" {
+			ret += " reason=" + quoteValue(reason)
+		}
+		sig := res.Signature
+		if sig == nil {
+			continue
+		}
+		ret += " header.d=" + quoteValue(sig.Domain)
+		ret += " header.s=" + quoteValue(sig.Selector)
+		if sig.Identity != "" {
+			ret += " header.i=" + quoteValue(sig.Identity)
+		} else {
+			ret += " header.i=" + quoteValue("@"+sig.Domain)
+		}
+		if b := sig.Body; b != "" {
+			// RFC 6008 only needs enough of the signature to tell
+			// signatures on the same message apart.
+			if len(b) > 8 {
+				b = b[:8]
+			}
+			ret += " header.b=" + quoteValue(b)
+		}
+	}
+	return ret
+}
+
+// errorReason describes err for the reason of a result.
+func errorReason(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.description()
+	}
+	return err.Error()
+}
+
+// quoteValue returns s as a value for an Authentication-Results header,
+// quoting it unless it's a MIME token or an address.
+func quoteValue(s string) string {
+	if s == "" {
+		return `""`
+	}
+	s = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, s)
+	if !strings.ContainsAny(s, " ()<>,;:\\\"/[]?=") {
+		return s
+	}
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + s + `"`
+}
+
+// AuthResults is a parsed Authentication-Results header field.
+type AuthResults struct {
+	// AuthServID identifies the server which added the header.
+	AuthServID string
+
+	// Version is the version of the header field, which is 1 if it's
+	// not given.
+	Version int
+
+	// Results holds the result of each method. It's empty if the
+	// header says that no methods were applied ("none").
+	Results []MethodResult
+}
+
+// MethodResult is the result of one authentication method in an
+// Authentication-Results header field, such as "dkim=pass".
+type MethodResult struct {
+	// The method, and its version if one was given.
+	Method, Version string
+
+	// The result of the method, such as "pass" or "fail".
+	Result string
+
+	// Reason is the value of the reason= property, if present.
+	Reason string
+
+	Properties []Property
+}
+
+// A Property is a property of a method result, such as "header.d" in
+// "header.d=example.com".
+type Property struct {
+	// The type of the property ("smtp", "header", "body" or "policy")
+	// and the property itself ("d" in header.d).
+	Type, Name string
+
+	Value string
+}
+
+// Property returns the value of the property with the type ptype and name
+// name, such as "header" and "d" for header.d, and whether the result had
+// the property.
+func (m MethodResult) Property(ptype, name string) (string, bool) {
+	for _, p := range m.Properties {
+		if strings.EqualFold(p.Type, ptype) && strings.EqualFold(p.Name, name) {
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
+// ParseAuthenticationResults parses the Authentication-Results header field
+// header, as described in RFC 8601. Comments are ignored.
+func ParseAuthenticationResults(header []byte) (*AuthResults, error) {
+	split := strings.SplitN(string(header), ":", 2)
+	if len(split) != 2 || !strings.EqualFold(strings.TrimSpace(split[0]), "authentication-results") {
+		return nil, permFail(ReasonSyntax, "not an Authentication-Results header")
+	}
+	p := &authresParser{s: split[1]}
+	ar, err := p.parse()
+	if err != nil {
+		return nil, permFail(ReasonSyntax, "invalid Authentication-Results: %v", err)
+	}
+	return ar, nil
+}
+
+// TrustedAuthenticationResults reads the headers of the message from r and
+// returns the Authentication-Results header fields which were added by one
+// of the servers in trusted, in the order that they appear in the message.
+// Header fields that can't be parsed are ignored.
+//
+// Only servers which remove Authentication-Results header fields claiming
+// to be from them when messages enter (as described in RFC 8601 section 5)
+// should be trusted, since anyone can add a header field with any
+// authserv-id.
+func TrustedAuthenticationResults(r io.Reader, trusted []string) ([]*AuthResults, error) {
+	headers, err := readBufferedHeaders(bufio.NewReader(r), 0)
+	if err != nil {
+		return nil, err
+	}
+	var results []*AuthResults
+	for _, h := range headers {
+		if h.name() != "authentication-results" {
+			continue
+		}
+		ar, err := ParseAuthenticationResults(h.Raw)
+		if err != nil {
+			continue
+		}
+		for _, id := range trusted {
+			if strings.EqualFold(ar.AuthServID, id) {
+				results = append(results, ar)
+				break
+			}
+		}
+	}
+	return results, nil
+}
+
+// ReplaceAuthenticationResults copies the message from r to w, adding the
+// Authentication-Results header field header (usually from
+// AuthenticationResults) to the top of it, after any mbox "From " lines.
+//
+// Any Authentication-Results header fields already in the message which
+// claim to be from the same authserv-id as header are removed, as required
+// by RFC 8601 section 5, so that they can't be mistaken for the new one.
+// If rename is not empty, they're renamed to it (for instance
+// "X-Forged-Authentication-Results") instead.
+//
+// Header fields of any length are copied, so that a message which couldn't
+// be verified because of a long header field can still be delivered with
+// its results.
+//
+// Newlines in r must already be in CRLF format.
+func ReplaceAuthenticationResults(r io.Reader, w io.Writer, header, rename string) error {
+	ar, err := ParseAuthenticationResults([]byte(header))
+	if err != nil {
+		return err
+	}
+	br := bufio.NewReader(r)
+	added := false
+	add := func() error {
+		if added {
+			return nil
+		}
+		added = true
+		_, err := fmt.Fprintf(w, "%v\r\n", header)
+		return err
+	}
+	for {
+		raw, err := readBufferedHeader(br, -1)
+		if err == HeaderEnd || err == io.EOF {
+			if err := add(); err != nil {
+				return err
+			}
+			if err == HeaderEnd {
+				if _, err := w.Write([]byte("\r\n")); err != nil {
+					return err
+				}
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if !bytes.HasPrefix(raw, []byte("From ")) {
+			if err := add(); err != nil {
+				return err
+			}
+		}
+		if claimsAuthServID(raw, ar.AuthServID) {
+			if rename == "" {
+				continue
+			}
+			raw = append([]byte(rename), raw[bytes.IndexByte(raw, ':'):]...)
+		}
+		if _, err := w.Write(raw); err != nil {
+			return err
+		}
+	}
+	_, err = io.Copy(w, br)
+	return err
+}
+
+// claimsAuthServID reports whether the header field raw is an
+// Authentication-Results header field from authservID. The rest of the
+// header field doesn't need to be valid.
+func claimsAuthServID(raw []byte, authservID string) bool {
+	if !bytes.HasPrefix(relaxHeader(raw), []byte("authentication-results:")) {
+		return false
+	}
+	p := &authresParser{s: string(raw[bytes.IndexByte(raw, ':')+1:])}
+	p.skipCFWS()
+	id, err := p.value()
+	return err == nil && strings.EqualFold(id, authservID)
+}
+
+// authresParser parses the value of an Authentication-Results header.
+type authresParser struct {
+	s   string
+	pos int
+}
+
+func (p *authresParser) parse() (*AuthResults, error) {
+	ar := &AuthResults{Version: 1}
+	p.skipCFWS()
+	id, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		return nil, fmt.Errorf("missing authserv-id")
+	}
+	ar.AuthServID = id
+	p.skipCFWS()
+	if p.pos < len(p.s) && p.s[p.pos] != ';' {
+		v, err := strconv.Atoi(p.word())
+		if err != nil {
+			return nil, fmt.Errorf("invalid version")
+		}
+		ar.Version = v
+		p.skipCFWS()
+	}
+	for p.pos < len(p.s) {
+		if !p.consume(';') {
+			return nil, fmt.Errorf("expected ';' at %d", p.pos)
+		}
+		p.skipCFWS()
+		if p.pos == len(p.s) {
+			// A trailing semicolon.
+			break
+		}
+		res, err := p.result()
+		if err != nil {
+			return nil, err
+		}
+		if res.Method == "none" && res.Result == "" {
+			if len(ar.Results) > 0 {
+				return nil, fmt.Errorf("none with other results")
+			}
+			continue
+		}
+		ar.Results = append(ar.Results, *res)
+	}
+	return ar, nil
+}
+
+// result parses a single method result.
+func (p *authresParser) result() (*MethodResult, error) {
+	res := &MethodResult{Method: strings.ToLower(p.word())}
+	if res.Method == "" {
+		return nil, fmt.Errorf("missing method at %d", p.pos)
+	}
+	p.skipCFWS()
+	if p.consume('/') {
+		p.skipCFWS()
+		res.Version = p.word()
+		p.skipCFWS()
+	}
+	if res.Method == "none" && res.Version == "" && (p.pos == len(p.s) || p.s[p.pos] == ';') {
+		return res, nil
+	}
+	if !p.consume('=') {
+		return nil, fmt.Errorf("expected '=' after method %v", res.Method)
+	}
+	p.skipCFWS()
+	if res.Result = strings.ToLower(p.word()); res.Result == "" {
+		return nil, fmt.Errorf("missing result for method %v", res.Method)
+	}
+	for {
+		p.skipCFWS()
+		if p.pos == len(p.s) || p.s[p.pos] == ';' {
+			return res, nil
+		}
+		name := p.word()
+		p.skipCFWS()
+		if name == "" || !p.consume('=') {
+			return nil, fmt.Errorf("invalid property at %d", p.pos)
+		}
+		p.skipCFWS()
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(name, "reason") {
+			res.Reason = value
+			continue
+		}
+		dot := strings.Index(name, ".")
+		if dot < 0 {
+			return nil, fmt.Errorf("invalid property %v", name)
+		}
+		res.Properties = append(res.Properties, Property{
+			Type:  strings.ToLower(name[:dot]),
+			Name:  name[dot+1:],
+			Value: value,
+		})
+	}
+}
+
+// consume consumes the next character if it's c.
+func (p *authresParser) consume(c byte) bool {
+	if p.pos < len(p.s) && p.s[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// skipCFWS skips over whitespace and comments.
+func (p *authresParser) skipCFWS() {
+	depth := 0
+	for ; p.pos < len(p.s); p.pos++ {
+		switch c := p.s[p.pos]; {
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case c == '\\' && depth > 0:
+			p.pos++
+		case c == ' ', c == '\t', c == '\r', c == '\n':
+		case depth == 0:
+			return
+		}
+	}
+}
+
+// word returns the next run of characters which are not whitespace or
+// special in a method or property name.
+func (p *authresParser) word() string {
+	start := p.pos
+	for p.pos < len(p.s) && !strings.ContainsRune(" \t\r\n()\";=/", rune(p.s[p.pos])) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// value returns the next value, which is either a quoted string or a
+// run of characters up to the next whitespace, comment or semicolon.
+func (p *authresParser) value() (string, error) {
+	if !p.consume('"') {
+		start := p.pos
+		for p.pos < len(p.s) && !strings.ContainsRune(" \t\r\n();", rune(p.s[p.pos])) {
+			p.pos++
+		}
+		return p.s[start:p.pos], nil
+	}
+	var v []byte
+	for ; p.pos < len(p.s); p.pos++ {
+		switch c := p.s[p.pos]; c {
+		case '"':
+			p.pos++
+			return string(v), nil
+		case '\\':
+			if p.pos+1 < len(p.s) {
+				p.pos++
+				v = append(v, p.s[p.pos])
+			}
+		case '\r', '\n':
+			// Folding whitespace inside the quotes.
+		default:
+			v = append(v, c)
+		}
+	}
+	return "", fmt.Errorf("unterminated quoted string")
+}
@@ -0,0 +1,250 @@
+package dkim
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAuthenticationResults(t *testing.T) {
+	sig := &Signature{
+		Domain:   "example.com",
+		Selector: "foo",
+		Body:     "dzdVyOfAKCdLXdJOc9G2q8LoXSlEniSbav+yuU4zGeeruD00lszZVoG4ZHRNiYzR",
+	}
+	tests := []struct {
+		results  []Result
+		err      error
+		expected string
+	}{
+		{nil, nil, "Authentication-Results: mx.example.org; dkim=none"},
+		{nil, ErrNoSignature, "Authentication-Results: mx.example.org; dkim=none"},
+		// Errors that stopped the message from being verified at all.
+		{nil, errHeaderTooLong(DefaultMaxHeaderLength), "Authentication-Results: mx.example.org; dkim=permerror reason=\"header field longer than 1048576 bytes\""},
+		{nil, tempFail(ReasonDNSTimeout, nil), "Authentication-Results: mx.example.org; dkim=temperror reason=\"DNS timeout\""},
+		{
+			[]Result{{Signature: sig, Status: Pass}},
+			nil,
+			"Authentication-Results: mx.example.org;\r\n\tdkim=pass header.d=example.com header.s=foo header.i=@example.com header.b=dzdVyOfA",
+		},
+		{
+			[]Result{
+				{Signature: &Signature{Domain: "example.com", Selector: "bar", Identity: "user@mail.example.com", Body: "a/b+c=="}, Status: Fail, Err: ErrBodyHashMismatch},
+				{Status: PermError, Err: permFail(ReasonSyntax, "invalid DKIM-Signature")},
+				{Signature: sig, Status: TempError, Err: tempFail(ReasonDNSTimeout, nil)},
+			},
+			nil,
+			"Authentication-Results: mx.example.org;\r\n" +
+				"\tdkim=fail reason=\"body hash does not match\" header.d=example.com header.s=bar header.i=user@mail.example.com header.b=\"a/b+c==\";\r\n" +
+				"\tdkim=permerror reason=\"invalid DKIM-Signature\";\r\n" +
+				"\tdkim=temperror reason=\"DNS timeout\" header.d=example.com header.s=foo header.i=@example.com header.b=dzdVyOfA",
+		},
+		// Results from keys in testing mode aren't authoritative.
+		{
+			[]Result{
+				{Signature: sig, Status: Pass, Testing: true},
+				{Signature: sig, Status: Fail, Err: ErrSignatureMismatch, Testing: true},
+			},
+			nil,
+			"Authentication-Results: mx.example.org;\r\n" +
+				"\tdkim=neutral reason=\"key in testing mode\" header.d=example.com header.s=foo header.i=@example.com header.b=dzdVyOfA;\r\n" +
+				"\tdkim=neutral reason=\"key in testing mode: signature does not verify\" header.d=example.com header.s=foo header.i=@example.com header.b=dzdVyOfA",
+		},
+	}
+	for i, tc := range tests {
+		if got := AuthenticationResults("mx.example.org", tc.results, tc.err); got != tc.expected {
+			t.Errorf("Case %d: got %q want %q", i, got, tc.expected)
+		}
+	}
+}
+
+func TestQuoteValue(t *testing.T) {
+	tests := []struct {
+		value, expected string
+	}{
+		{"example.com", "example.com"},
+		{"user@example.com", "user@example.com"},
+		{"", `""`},
+		{"two words", `"two words"`},
+		{`say "hi"\n`, `"say \"hi\"\\n"`},
+		{"line\r\nbreak", `"line  break"`},
+	}
+	for i, tc := range tests {
+		if got := quoteValue(tc.value); got != tc.expected {
+			t.Errorf("Case %d: got %v want %v", i, got, tc.expected)
+		}
+	}
+}
+
+func TestParseAuthenticationResults(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected *AuthResults
+	}{
+		// Examples from RFC 8601 appendix B
+		{
+			"Authentication-Results: example.org 1; none",
+			&AuthResults{AuthServID: "example.org", Version: 1},
+		},
+		{
+			"Authentication-Results: example.com;\r\n          spf=pass smtp.mailfrom=example.net",
+			&AuthResults{AuthServID: "example.com", Version: 1, Results: []MethodResult{
+				{Method: "spf", Result: "pass", Properties: []Property{{"smtp", "mailfrom", "example.net"}}},
+			}},
+		},
+		{
+			"Authentication-Results: example.com;\r\n          auth=pass (cram-md5) smtp.auth=sender@example.net;\r\n          spf=pass smtp.mailfrom=example.net",
+			&AuthResults{AuthServID: "example.com", Version: 1, Results: []MethodResult{
+				{Method: "auth", Result: "pass", Properties: []Property{{"smtp", "auth", "sender@example.net"}}},
+				{Method: "spf", Result: "pass", Properties: []Property{{"smtp", "mailfrom", "example.net"}}},
+			}},
+		},
+		{
+			"Authentication-Results: mail-router.example.net;\r\n" +
+				"    dkim=pass (good signature) header.d=newyork.example.com\r\n" +
+				"          header.b=oINEO8hg;\r\n" +
+				"    dkim=fail (bad signature) header.d=mail-router.example.net\r\n" +
+				"          header.b=EToRSuvU",
+			&AuthResults{AuthServID: "mail-router.example.net", Version: 1, Results: []MethodResult{
+				{Method: "dkim", Result: "pass", Properties: []Property{{"header", "d", "newyork.example.com"}, {"header", "b", "oINEO8hg"}}},
+				{Method: "dkim", Result: "fail", Properties: []Property{{"header", "d", "mail-router.example.net"}, {"header", "b", "EToRSuvU"}}},
+			}},
+		},
+		// Method versions, reasons, quoting and comments in odd places
+		{
+			"authentication-results: \"mx.example.org\" (comment (nested)); DKIM/1 = Pass reason=\"good \\\"sig\\\"\" header.i=\"user@example.com\" (end);",
+			&AuthResults{AuthServID: "mx.example.org", Version: 1, Results: []MethodResult{
+				{Method: "dkim", Version: "1", Result: "pass", Reason: `good "sig"`, Properties: []Property{{"header", "i", "user@example.com"}}},
+			}},
+		},
+		// Invalid headers
+		{"Authentication-Results: ; dkim=pass", nil},
+		{"Authentication-Results: example.com; dkim", nil},
+		{"Authentication-Results: example.com; dkim=", nil},
+		{"Authentication-Results: example.com; dkim=pass header.d", nil},
+		{"Authentication-Results: example.com; dkim=pass d=example.com", nil},
+		{"Authentication-Results: example.com; dkim=pass reason=\"unterminated", nil},
+		{"Authentication-Results: example.com x; none", nil},
+		{"Received-SPF: example.com; none", nil},
+	}
+	for i, tc := range tests {
+		got, err := ParseAuthenticationResults([]byte(tc.header))
+		if tc.expected == nil {
+			if err == nil {
+				t.Errorf("Case %d: invalid header was parsed: %v", i, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Case %d: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("Case %d: got %+v want %+v", i, got, tc.expected)
+		}
+	}
+
+	// The header from AuthenticationResults can be parsed.
+	header := AuthenticationResults("mx.example.org", []Result{{
+		Signature: &Signature{Domain: "example.com", Selector: "foo", Body: "a/b+c=="},
+		Status:    Fail,
+		Err:       ErrBodyHashMismatch,
+	}}, nil)
+	ar, err := ParseAuthenticationResults([]byte(header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ar.Results) != 1 || ar.Results[0].Result != "fail" || ar.Results[0].Reason != "body hash does not match" {
+		t.Fatalf("Unexpected results: %+v", ar)
+	}
+	if b, ok := ar.Results[0].Property("header", "b"); !ok || b != "a/b+c==" {
+		t.Errorf("Unexpected header.b: %v", b)
+	}
+}
+
+func TestTrustedAuthenticationResults(t *testing.T) {
+	var msg = "Authentication-Results: mx.example.org;\r\n\tdkim=pass header.d=example.com\r\n" +
+		"Authentication-Results: untrusted.example.net; dkim=pass header.d=example.com\r\n" +
+		"Authentication-Results: MX.example.org; dkim=fail header.d=example.net\r\n" +
+		"Authentication-Results: mx.example.org; this is not valid\r\n" +
+		"From: Test <test@example.com>\r\n" +
+		"\r\n" +
+		"Authentication-Results: mx.example.org; dkim=pass\r\n"
+	results, err := TrustedAuthenticationResults(strings.NewReader(msg), []string{"relay.example.org", "mx.example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Got %d results want 2: %v", len(results), results)
+	}
+	for i, want := range []string{"example.com", "example.net"} {
+		if d, _ := results[i].Results[0].Property("header", "d"); d != want {
+			t.Errorf("Result %d: got header.d=%v want %v", i, d, want)
+		}
+	}
+}
+
+func TestReplaceAuthenticationResults(t *testing.T) {
+	const header = "Authentication-Results: mx.example.org; dkim=pass header.d=example.com"
+	var msg = "From sender@example.com Mon Jan  1 00:00:00 2024\r\n" +
+		"Authentication-Results: mx.example.org; dkim=pass header.d=evil.example\r\n" +
+		"Authentication-Results: relay.example.net; dkim=fail\r\n" +
+		"authentication-results : (forged) MX.Example.Org;\r\n\tnot valid\r\n" +
+		"From: Test <test@example.com>\r\n" +
+		"\r\n" +
+		"Authentication-Results: mx.example.org; dkim=pass\r\n"
+	tests := []struct {
+		rename   string
+		expected string
+	}{
+		{
+			"",
+			"From sender@example.com Mon Jan  1 00:00:00 2024\r\n" +
+				header + "\r\n" +
+				"Authentication-Results: relay.example.net; dkim=fail\r\n" +
+				"From: Test <test@example.com>\r\n" +
+				"\r\n" +
+				"Authentication-Results: mx.example.org; dkim=pass\r\n",
+		},
+		{
+			"X-Forged-Authentication-Results",
+			"From sender@example.com Mon Jan  1 00:00:00 2024\r\n" +
+				header + "\r\n" +
+				"X-Forged-Authentication-Results: mx.example.org; dkim=pass header.d=evil.example\r\n" +
+				"Authentication-Results: relay.example.net; dkim=fail\r\n" +
+				"X-Forged-Authentication-Results: (forged) MX.Example.Org;\r\n\tnot valid\r\n" +
+				"From: Test <test@example.com>\r\n" +
+				"\r\n" +
+				"Authentication-Results: mx.example.org; dkim=pass\r\n",
+		},
+	}
+	for i, tc := range tests {
+		var out bytes.Buffer
+		if err := ReplaceAuthenticationResults(strings.NewReader(msg), &out, header, tc.rename); err != nil {
+			t.Fatalf("Case %d: %v", i, err)
+		}
+		if got := out.String(); got != tc.expected {
+			t.Errorf("Case %d: got %q want %q", i, got, tc.expected)
+		}
+	}
+
+	// A message with no body still gets the header.
+	var out bytes.Buffer
+	if err := ReplaceAuthenticationResults(strings.NewReader("Subject: Hi\r\n"), &out, header, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), header+"\r\nSubject: Hi\r\n"; got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+
+	// Header fields too long to verify are still copied.
+	long := "X-Long: " + strings.Repeat("a", DefaultMaxHeaderLength) + "\r\n\r\nbody\r\n"
+	out.Reset()
+	if err := ReplaceAuthenticationResults(strings.NewReader(long), &out, header, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), header+"\r\n"+long; got != want {
+		t.Errorf("Long header field was not copied")
+	}
+}
@@ -3,9 +3,41 @@ package dkim
 import (
 	"bufio"
 	"bytes"
+	"encoding/base64"
+	"hash"
 	"io"
 )
 
+// ReadSMTPBodySimple reads the body of a message from r and returns it
+// canonicalized with the "simple" body canonicalization algorithm from
+// RFC 6376 section 3.4.3.
+//
+// Lines are left untouched, but any empty lines at the end of the body are
+// removed. An empty body is canonicalized to a single CRLF.
+func ReadSMTPBodySimple(r io.Reader) (raw []byte, err error) {
+	linescan := bufio.NewScanner(r)
+	for linescan.Scan() {
+		raw = append(raw, linescan.Bytes()...)
+		raw = append(raw, '\r', '\n')
+	}
+	if err := linescan.Err(); err != nil {
+		return nil, err
+	}
+
+	// Trim trailing empty lines, but leave the CRLF that ends the last
+	// non-empty line.
+	for bytes.HasSuffix(raw, []byte("\r\n\r\n")) {
+		raw = raw[:len(raw)-2]
+	}
+	if len(raw) == 0 || string(raw) == "\r\n" {
+		return []byte("\r\n"), nil
+	}
+	return raw, nil
+}
+
+// ReadSMTPBodyRelaxed reads the body of a message from r and returns it
+// canonicalized with the "relaxed" body canonicalization algorithm from
+// RFC 6376 section 3.4.4.
 func ReadSMTPBodyRelaxed(r io.Reader) (raw []byte, err error) {
 	linescan := bufio.NewScanner(r)
 	for linescan.Scan() {
@@ -31,3 +63,145 @@ func ReadSMTPBodyRelaxed(r io.Reader) (raw []byte, err error) {
 	}
 	return append(raw, '\r', '\n'), nil
 }
+
+// ReadSMTPBody reads the body of a message from r and canonicalizes it
+// according to canon, which must be either "simple" or "relaxed".
+func ReadSMTPBody(r io.Reader, canon string) ([]byte, error) {
+	switch canon {
+	case "simple", "":
+		return ReadSMTPBodySimple(r)
+	case "relaxed":
+		return ReadSMTPBodyRelaxed(r)
+	}
+	return nil, permFail(ReasonUnknownAlgorithm, "unknown body canonicalization %v", canon)
+}
+
+// A bodyHasher canonicalizes and hashes the body of a message for a
+// signature as it's written, without keeping the body in memory.
+//
+// Empty lines are only counted until a non-empty line is written, so that
+// trailing empty lines can be removed from the end of the body.
+type bodyHasher struct {
+	h       hash.Hash
+	relaxed bool
+	limit   *int64
+
+	// written is the total number of canonicalized bytes, including
+	// any past the length limit.
+	written int64
+
+	emptyLines int  // Empty lines which haven't been written yet.
+	inLine     bool // Part of the current line has been written.
+	cr         bool // The last byte was a carriage return.
+	space      bool // There is whitespace pending in a relaxed line.
+}
+
+// newBodyHasher returns a bodyHasher which canonicalizes the body
+// according to sig.
+func newBodyHasher(sig *Signature) (*bodyHasher, error) {
+	b := &bodyHasher{limit: sig.Length}
+	switch sig.BodyCanonicalization {
+	case "simple", "":
+	case "relaxed":
+		b.relaxed = true
+	default:
+		return nil, permFail(ReasonUnknownAlgorithm, "unknown body canonicalization %v", sig.BodyCanonicalization)
+	}
+	hash, err := hashAlgorithm(sig.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	b.h = hash.New()
+	return b, nil
+}
+
+// emit writes canonicalized bytes to the hash, up to the length limit.
+func (b *bodyHasher) emit(p ...byte) {
+	if b.limit != nil {
+		if left := *b.limit - b.written; left < int64(len(p)) {
+			b.written += int64(len(p))
+			if left > 0 {
+				b.h.Write(p[:left])
+			}
+			return
+		}
+	}
+	b.written += int64(len(p))
+	b.h.Write(p)
+}
+
+// startLine writes any pending empty lines before the content of a
+// non-empty line.
+func (b *bodyHasher) startLine() {
+	for ; b.emptyLines > 0; b.emptyLines-- {
+		b.emit('\r', '\n')
+	}
+	b.inLine = true
+}
+
+func (b *bodyHasher) endLine() {
+	if b.inLine {
+		b.emit('\r', '\n')
+	} else {
+		b.emptyLines++
+	}
+	b.inLine, b.space = false, false
+}
+
+func (b *bodyHasher) Write(p []byte) (int, error) {
+	for _, c := range p {
+		if b.cr {
+			b.cr = false
+			if c == '\n' {
+				b.endLine()
+				continue
+			}
+			// A carriage return in the middle of a line is
+			// whitespace for relaxed canonicalization, and
+			// left alone for simple.
+			if b.relaxed {
+				b.space = true
+			} else {
+				b.startLine()
+				b.emit('\r')
+			}
+		}
+		switch {
+		case c == '\r':
+			b.cr = true
+		case c == '\n':
+			b.endLine()
+		case b.relaxed && (c == ' ' || c == '\t'):
+			b.space = true
+		default:
+			b.startLine()
+			if b.space {
+				b.emit(' ')
+				b.space = false
+			}
+			b.emit(c)
+		}
+	}
+	return len(p), nil
+}
+
+// Sum finishes canonicalizing the body, and returns the base64 encoded
+// hash along with the number of canonicalized bytes which were excluded
+// by the length limit.
+func (b *bodyHasher) Sum() (encoded string, unsigned int64, err error) {
+	if b.inLine {
+		b.endLine()
+	}
+	if b.written == 0 && !b.relaxed {
+		// The simple canonicalization of an empty body is a
+		// single CRLF.
+		b.emit('\r', '\n')
+	}
+	if b.limit != nil {
+		if *b.limit > b.written {
+			return "", 0, permFail(ReasonBodyHashMismatch, "body is shorter than the signature length limit")
+		}
+		unsigned = b.written - *b.limit
+	}
+	return base64.StdEncoding.EncodeToString(b.h.Sum(nil)), unsigned, nil
+}
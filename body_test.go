@@ -1,48 +1,43 @@
 package dkim
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"strings"
 	"testing"
 )
 
-/*
 func TestSimpleBody(t *testing.T) {
 	tests := []struct {
 		rawbody  string
-		expected []string
+		expected string
 	}{
-		// Normal case
-		{"foo: bar\r\n", []string{"foo: bar\r\n"}},
-		// Ensure it doesn't change header case
-		{"Foo: bar\r\n", []string{"Foo: bar\r\n"}},
-		// See what happens with no line ending. (This shouldn't be
-		// possible with SMTP.. but if it happens the whitespace shouldn't
-		// be modified.)
-		{"Foo: bar", []string{"Foo: bar"}},
-		// Ensure it breaks headers at the right place in the normal case
-		{"Foo: bar\r\nBar: foo\r\n", []string{"Foo: bar\r\n", "Bar: foo\r\n"}},
-		// Ensure continuation lines are part of the right header, but not modified
+		// An empty body is a single CRLF
+		{"", "\r\n"},
+		{"\r\n\r\n", "\r\n"},
+		// Whitespace is not modified, but trailing empty lines are
+		// removed.
 		{
-			"Foo: Bar\r\n with continuation\r\nBar: end\r\n",
-			[]string{"Foo: Bar\r\n with continuation\r\n", "Bar: end\r\n"},
+			"foo      \r\nbar\r\n \tbaz   \r\n\r\n\r\n",
+			"foo      \r\nbar\r\n \tbaz   \r\n",
 		},
+		// Empty lines in the middle of the body are kept
+		{"foo\r\n\r\nbar\r\n", "foo\r\n\r\nbar\r\n"},
 	}
 
 	for i, tc := range tests {
 		body := strings.NewReader(tc.rawbody)
-		for j, h := range tc.expected {
-			_, converted, err := ReadSMTPHeaderSimple(body)
-			if err != nil {
-				t.Errorf("Case %d, Header %d: %v", i, j, err)
-				continue
-			}
-			if string(converted) != h {
-				t.Errorf("Case %d, Header %d: got %v want %v", i, j, string(converted), h)
-			}
+		got, err := ReadSMTPBodySimple(body)
+		if err != nil {
+			t.Errorf("Case %d: %v", i, err)
+			continue
 		}
+		if string(got) != tc.expected {
+			t.Errorf("Case %d: got `%s` want `%s`", i, string(got), tc.expected)
+		}
+
 	}
 }
-*/
 
 func TestRelaxeBody(t *testing.T) {
 	tests := []struct {
@@ -70,3 +65,42 @@ func TestRelaxeBody(t *testing.T) {
 
 	}
 }
+
+func TestBodyHasher(t *testing.T) {
+	bodies := []string{
+		"",
+		"\r\n\r\n",
+		"foo      \r\nbar\r\n \tbaz   \r\n\r\n\r\n",
+		"foo\r\n\r\nbar\r\n",
+		"  leading\t and trailing \t\r\n \r\n\t\r\n",
+		"no line ending",
+		"carriage\rreturn\r\n\r\r\n",
+	}
+	for _, canon := range []string{"simple", "relaxed"} {
+		for i, body := range bodies {
+			want, err := ReadSMTPBody(strings.NewReader(body), canon)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sig := &Signature{Algorithm: "rsa-sha256", BodyCanonicalization: canon}
+			h, err := newBodyHasher(sig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Write a byte at a time to make sure that nothing
+			// depends on where the writes are split.
+			for j := 0; j < len(body); j++ {
+				h.Write([]byte{body[j]})
+			}
+			got, _, err := h.Sum()
+			if err != nil {
+				t.Errorf("%v case %d: %v", canon, i, err)
+				continue
+			}
+			hash := sha256.Sum256(want)
+			if got != base64.StdEncoding.EncodeToString(hash[:]) {
+				t.Errorf("%v case %d: body hash does not match canonicalized body `%s`", canon, i, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package dkim
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// The default settings of a KeyCache returned from NewKeyCache.
+const (
+	DefaultKeyTTL         = time.Hour
+	DefaultNegativeKeyTTL = 5 * time.Minute
+	DefaultMaxKeys        = 10000
+)
+
+// A KeyCache caches the public keys looked up by a Verifier, so that
+// verifying many messages from the same signer doesn't look up the same
+// key every time. It's safe to use a KeyCache from multiple goroutines,
+// and to share one between Verifiers.
+//
+// Keys that were found are cached for TTL. Keys that don't exist or have
+// been revoked are cached for NegativeTTL, but temporary failures such as
+// DNS timeouts are never cached. If a key is already being looked up,
+// other lookups for it wait for the result instead of making another
+// query.
+//
+// The settings shouldn't be changed once the KeyCache is in use.
+type KeyCache struct {
+	TTL, NegativeTTL time.Duration
+
+	// MaxEntries is the maximum number of keys to cache. Once it's
+	// reached, the least recently used key is evicted. 0 means there's
+	// no limit.
+	MaxEntries int
+
+	// Now returns the current time. If nil, time.Now is used.
+	Now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // Most recently used at the front.
+	stats   CacheStats
+}
+
+// CacheStats contains statistics about the use of a KeyCache.
+type CacheStats struct {
+	// The number of lookups that were answered by a cached key, or a
+	// cached failure to find one.
+	Hits, NegativeHits uint64
+
+	// The number of lookups that had to be sent to the resolver.
+	Misses uint64
+
+	// The number of keys removed from the cache to make room for
+	// others.
+	Evictions uint64
+
+	// The number of keys currently cached.
+	Entries int
+}
+
+type cacheEntry struct {
+	name    string
+	rec     *KeyRecord
+	err     error
+	expires time.Time
+
+	// ready is closed once the lookup has finished and the fields
+	// above are set.
+	ready chan struct{}
+}
+
+// NewKeyCache returns a KeyCache with the default settings.
+func NewKeyCache() *KeyCache {
+	return &KeyCache{
+		TTL:         DefaultKeyTTL,
+		NegativeTTL: DefaultNegativeKeyTTL,
+		MaxEntries:  DefaultMaxKeys,
+	}
+}
+
+// Stats returns statistics about the use of the cache so far.
+func (c *KeyCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = len(c.entries)
+	return stats
+}
+
+func (c *KeyCache) now() time.Time {
+	if c.Now == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
+
+// remove removes the entry el from the cache. c.mu must be held.
+func (c *KeyCache) remove(el *list.Element) {
+	delete(c.entries, el.Value.(*cacheEntry).name)
+	c.order.Remove(el)
+}
+
+// lookup returns the key record at name from the cache, looking it up
+// with the resolver r if it's not cached.
+func (c *KeyCache) lookup(ctx context.Context, r Resolver, name string) (*KeyRecord, error) {
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = make(map[string]*list.Element)
+		c.order = list.New()
+	}
+	if el, ok := c.entries[name]; ok {
+		e := el.Value.(*cacheEntry)
+		select {
+		case <-e.ready:
+			if c.now().Before(e.expires) {
+				c.order.MoveToFront(el)
+				if e.err != nil {
+					c.stats.NegativeHits++
+				} else {
+					c.stats.Hits++
+				}
+				c.mu.Unlock()
+				return e.rec, e.err
+			}
+			c.remove(el)
+		default:
+			// Someone else is already looking it up, so wait
+			// for them.
+			c.mu.Unlock()
+			select {
+			case <-e.ready:
+				return e.rec, e.err
+			case <-ctx.Done():
+				return nil, tempFail(ReasonDNSTimeout, ctx.Err())
+			}
+		}
+	}
+	c.stats.Misses++
+	e := &cacheEntry{name: name, ready: make(chan struct{})}
+	c.entries[name] = c.order.PushFront(e)
+	for c.MaxEntries > 0 && c.order.Len() > c.MaxEntries {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+	c.mu.Unlock()
+
+	rec, err := lookupKey(ctx, r, name)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e.rec, e.err = rec, err
+	switch {
+	case err == nil:
+		e.expires = c.now().Add(c.TTL)
+	case errors.Is(err, ErrPermFail):
+		e.expires = c.now().Add(c.NegativeTTL)
+	default:
+		// Temporary failures aren't cached, so the next lookup
+		// tries again.
+		if el, ok := c.entries[name]; ok && el.Value == e {
+			c.remove(el)
+		}
+	}
+	close(e.ready)
+	return rec, err
+}
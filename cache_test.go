@@ -0,0 +1,131 @@
+package dkim
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingResolver counts the queries sent to a mapResolver, and returns
+// a timeout for any name in timeouts.
+type countingResolver struct {
+	records  mapResolver
+	timeouts map[string]bool
+	queries  int32
+
+	// If not nil, queries wait until it's closed.
+	wait chan struct{}
+}
+
+func (c *countingResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	atomic.AddInt32(&c.queries, 1)
+	if c.wait != nil {
+		<-c.wait
+	}
+	if c.timeouts[name] {
+		return nil, &net.DNSError{Err: "i/o timeout", Name: name, IsTimeout: true}
+	}
+	return c.records.LookupTXT(ctx, name)
+}
+
+func TestKeyCache(t *testing.T) {
+	r := &countingResolver{
+		records: mapResolver{
+			"foo._domainkey.example.com":     {rfc8463DNSRecord},
+			"revoked._domainkey.example.com": {"v=DKIM1; k=rsa; p="},
+		},
+		timeouts: map[string]bool{"slow._domainkey.example.com": true},
+	}
+	now := time.Date(2018, time.January, 24, 16, 35, 4, 0, time.UTC)
+	c := NewKeyCache()
+	c.Now = func() time.Time { return now }
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		err     error
+		queries int32
+	}{
+		{"foo._domainkey.example.com", nil, 1},
+		{"foo._domainkey.example.com", nil, 1},
+		{"missing._domainkey.example.com", ErrKeyNotFound, 2},
+		{"missing._domainkey.example.com", ErrKeyNotFound, 2},
+		{"revoked._domainkey.example.com", ErrKeyRevoked, 3},
+		{"revoked._domainkey.example.com", ErrKeyRevoked, 3},
+		// Temporary failures are not cached.
+		{"slow._domainkey.example.com", ErrDNSTimeout, 4},
+		{"slow._domainkey.example.com", ErrDNSTimeout, 5},
+	}
+	for i, tc := range tests {
+		rec, err := c.lookup(ctx, r, tc.name)
+		if tc.err == nil && (err != nil || rec == nil) {
+			t.Errorf("Case %d: got %v, %v want a key", i, rec, err)
+		} else if tc.err != nil && !errors.Is(err, tc.err) {
+			t.Errorf("Case %d: got error %v want %v", i, err, tc.err)
+		}
+		if q := atomic.LoadInt32(&r.queries); q != tc.queries {
+			t.Errorf("Case %d: got %d queries want %d", i, q, tc.queries)
+		}
+	}
+	want := CacheStats{Hits: 1, NegativeHits: 2, Misses: 5, Entries: 3}
+	if stats := c.Stats(); stats != want {
+		t.Errorf("Unexpected stats: got %+v want %+v", stats, want)
+	}
+
+	// Negative entries expire before positive ones.
+	now = now.Add(DefaultNegativeKeyTTL + time.Second)
+	c.lookup(ctx, r, "foo._domainkey.example.com")
+	c.lookup(ctx, r, "missing._domainkey.example.com")
+	if q := atomic.LoadInt32(&r.queries); q != 6 {
+		t.Errorf("After negative TTL: got %d queries want 6", q)
+	}
+	now = now.Add(DefaultKeyTTL)
+	c.lookup(ctx, r, "foo._domainkey.example.com")
+	if q := atomic.LoadInt32(&r.queries); q != 7 {
+		t.Errorf("After TTL: got %d queries want 7", q)
+	}
+}
+
+func TestKeyCacheSize(t *testing.T) {
+	r := &countingResolver{records: mapResolver{}}
+	c := NewKeyCache()
+	c.MaxEntries = 2
+	for _, name := range []string{"a", "b", "a", "c", "a", "b"} {
+		c.lookup(context.Background(), r, name)
+	}
+	// b is evicted when c is added, since a was used more recently,
+	// and c is evicted when b is added again.
+	want := CacheStats{NegativeHits: 2, Misses: 4, Evictions: 2, Entries: 2}
+	if stats := c.Stats(); stats != want {
+		t.Errorf("Unexpected stats: got %+v want %+v", stats, want)
+	}
+}
+
+func TestKeyCacheConcurrent(t *testing.T) {
+	r := &countingResolver{
+		records: mapResolver{"foo._domainkey.example.com": {rfc8463DNSRecord}},
+		wait:    make(chan struct{}),
+	}
+	c := NewKeyCache()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.lookup(context.Background(), r, "foo._domainkey.example.com"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	// Give the lookups a chance to start before the answer comes back.
+	time.Sleep(10 * time.Millisecond)
+	close(r.wait)
+	wg.Wait()
+	if q := atomic.LoadInt32(&r.queries); q != 1 {
+		t.Errorf("Got %d queries for the same key want 1", q)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 
@@ -10,21 +11,56 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 )
 
 func main() {
-	pk, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
-	}
+	keytype := flag.String("t", "rsa", "Type of key to generate (rsa or ed25519)")
+	flag.Parse()
 
-	asn1bytes, err := x509.MarshalPKIXPublicKey(&pk.PublicKey)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(2)
+	var pubkey []byte
+	var block *pem.Block
+	switch *keytype {
+	case "rsa":
+		pk, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		asn1bytes, err := x509.MarshalPKIXPublicKey(&pk.PublicKey)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		pubkey = asn1bytes
+		block = &pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(pk),
+		}
+	case "ed25519":
+		pub, pk, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		// RFC 8463 publishes the raw key in DNS, not the ASN.1
+		// encoding.
+		pubkey = pub
+		pkcs8, err := x509.MarshalPKCS8PrivateKey(pk)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		block = &pem.Block{
+			Type:  "PRIVATE KEY",
+			Bytes: pkcs8,
+		}
+	default:
+		fmt.Fprintln(os.Stderr, "Unsupported key type")
+		os.Exit(1)
 	}
 
 	f, err := os.Create("dns.txt")
@@ -33,8 +69,8 @@ func main() {
 		os.Exit(3)
 	}
 
-	b64 := base64.StdEncoding.EncodeToString(asn1bytes)
-	fmt.Fprintf(f, "v=DKIM1; k=rsa; p=%s", b64)
+	b64 := base64.StdEncoding.EncodeToString(pubkey)
+	fmt.Fprintf(f, "v=DKIM1; k=%s; p=%s", *keytype, b64)
 	f.Close()
 
 	f, err = os.Create("private.pem")
@@ -42,10 +78,7 @@ func main() {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(4)
 	}
-	err = pem.Encode(f, &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(pk),
-	})
+	err = pem.Encode(f, block)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(5)
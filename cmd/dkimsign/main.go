@@ -6,47 +6,52 @@ import (
 	"io/ioutil"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/driusan/dkim"
 
-	"crypto/rsa"
+	"crypto"
+	"crypto/ed25519"
 	"crypto/x509"
 	"encoding/pem"
 )
 
-func signmessage(sig dkim.Signature, key *rsa.PrivateKey, unix bool, dotstuffed bool, hdronly bool) error {
+func signmessage(sig dkim.Signature, key crypto.Signer, unix bool, dotstuffed bool, hdronly bool) error {
 	r := dkim.NormalizeReader(os.Stdin)
 	if dotstuffed {
 		r.Unstuff()
 	}
-	file, err := dkim.FileBuffer(r)
-	if err != nil {
-		return err
-	}
-	defer os.Remove(file.Name())
 
 	var nl string
 	if unix {
 		nl = "\n"
 	}
 	if hdronly {
-		return dkim.SignedHeader(sig, file, os.Stdout, key, nl)
+		return dkim.SignedHeader(sig, r, os.Stdout, key, nl)
 	}
-	return dkim.SignMessage(sig, file, os.Stdout, key, nl)
+	return dkim.SignMessage(sig, r, os.Stdout, key, nl)
 }
 
 func main() {
 	var canon string = "relaxed/relaxed"
-	var s, domain string
-	var headers string
+	var s, domain, identity string
+	var headers, oversign, include, exclude string
 	var unstuff bool
 	var headeronly bool
+	var length int64
+	var expiry time.Duration
 	flag.StringVar(&canon, "c", "relaxed/relaxed", "Canonicalization scheme")
 	flag.StringVar(&s, "s", "", "Domain selector")
 	flag.StringVar(&domain, "d", "", "Domain name")
-	flag.StringVar(&headers, "h", "From:Subject:To:Date", "Colon separated list of headers to sign")
+	flag.StringVar(&identity, "i", "", "Identity of the user or agent the message is signed on behalf of")
+	flag.StringVar(&headers, "h", "", "Colon separated list of headers to sign (default: the recommended headers in the message)")
+	flag.StringVar(&include, "include", "", "Colon separated list of headers to sign in addition to the recommended ones, if present")
+	flag.StringVar(&exclude, "exclude", "", "Colon separated list of recommended headers not to sign")
+	flag.StringVar(&oversign, "o", "", "Colon separated list of headers to sign one more time than they appear, so that more can't be added")
 	flag.BoolVar(&unstuff, "u", false, "Assume input is already SMTP dot stuffed when calculating signature and un dot-stuff it while printing")
 	nl := flag.Bool("n", false, `Print final message with \n instead of \r\n line endings`)
+	flag.Int64Var(&length, "l", -1, "Only sign the first l bytes of the canonicalized body")
+	flag.DurationVar(&expiry, "x", 0, "Expire the signature after this duration (default: never)")
 	flag.BoolVar(&headeronly, "hd", false, "Only print the header, not the whole message after signing")
 	privatekey := flag.String("key", "", "Location of PEM encoded private key")
 	flag.Parse()
@@ -55,7 +60,7 @@ func main() {
 		fmt.Fprintln(os.Stderr, "Selector and domain are required")
 		os.Exit(1)
 	}
-	var key *rsa.PrivateKey
+	var key crypto.PrivateKey
 	kf, err := os.Open(*privatekey)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Could not open private key: %v\n", err)
@@ -70,23 +75,62 @@ func main() {
 	}
 
 	pemblock, _ := pem.Decode(keyfile)
-	if pemblock == nil || pemblock.Type != "RSA PRIVATE KEY" {
+	if pemblock == nil {
+		fmt.Fprintln(os.Stderr, "Could read private key or unsupported format")
+		os.Exit(1)
+	}
+	switch pemblock.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(pemblock.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(pemblock.Bytes)
+	default:
 		fmt.Fprintln(os.Stderr, "Could read private key or unsupported format")
 		os.Exit(1)
 	}
-	key, err = x509.ParsePKCS1PrivateKey(pemblock.Bytes)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Could not parse private key: %v\n", err)
 		os.Exit(1)
 	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Unsupported private key type")
+		os.Exit(1)
+	}
 
-	sig, err := dkim.NewSignature(canon, s, domain, strings.Split(headers, ":"))
+	var signed []string
+	if headers != "" {
+		signed = strings.Split(headers, ":")
+	}
+	sig, err := dkim.NewSignature(canon, s, domain, signed)
 
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
-	if err := signmessage(sig, key, *nl, unstuff, headeronly); err != nil {
+	if sig.HeaderSelection != nil {
+		if include != "" {
+			sig.HeaderSelection.Include = strings.Split(include, ":")
+		}
+		if exclude != "" {
+			sig.HeaderSelection.Exclude = strings.Split(exclude, ":")
+		}
+	}
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		sig.Algorithm = "ed25519-sha256"
+	}
+	sig.Identity = identity
+	if oversign != "" {
+		sig.Oversign = strings.Split(oversign, ":")
+	}
+	if length >= 0 {
+		sig.Length = &length
+	}
+	sig.Timestamp = time.Now()
+	if expiry > 0 {
+		sig.Expiration = sig.Timestamp.Add(expiry)
+	}
+	if err := signmessage(sig, signer, *nl, unstuff, headeronly); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(2)
 	}
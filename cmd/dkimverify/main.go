@@ -1,12 +1,17 @@
 package main
 
 import (
-	"crypto/rsa"
+	"bytes"
+	"context"
+	"crypto"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/driusan/dkim"
 )
@@ -16,78 +21,216 @@ func main() {
 	hd := flag.String("hd", "", "Print the results to an SMTP header on stdout instead of stderr")
 	hdprefix := flag.String("hdprefix", "", "Prefix the results of the header with this string")
 	hdsuffix := flag.String("hdsuffix", "", "Suffix the results of the header with this string")
+	ar := flag.String("ar", "", "Print an Authentication-Results header for this authserv-id to stdout instead of printing to stderr")
+	skew := flag.Duration("skew", dkim.DefaultClockSkew, "Allowed clock skew when checking signature timestamps")
+	resolver := flag.String("resolver", "", "Send DNS queries to this server (host:port) instead of the system resolver")
+	timeout := flag.Duration("timeout", 0, "Give up on DNS lookups for a message after this duration (default: no limit)")
+	minbits := flag.Int("minbits", dkim.DefaultMinRSAKeyBits, "Reject signatures made with RSA keys shorter than this many bits")
+	algorithms := flag.String("algorithms", strings.Join(dkim.DefaultAlgorithms, ":"), "Colon separated list of signing algorithms to accept")
+	require := flag.String("require", strings.Join(dkim.DefaultRequiredHeaders, ":"), "Colon separated list of headers that signatures must cover (From is always required by RFC 6376)")
+	filter := flag.Bool("filter", false, "Print the message from stdin with the Authentication-Results header from -ar added, removing any others from the same authserv-id")
+	rename := flag.String("rename", "", "With -filter, rename Authentication-Results headers from the same authserv-id to this instead of removing them")
+	unstuff := flag.Bool("u", false, "Assume input is already SMTP dot stuffed and un dot-stuff it")
+	nl := flag.Bool("n", false, `With -filter, print the message with \n instead of \r\n line endings`)
 	flag.Parse()
 
-	var key *rsa.PublicKey
+	v := dkim.NewVerifier()
+	v.ClockSkew = *skew
+	v.MinRSAKeyBits = *minbits
+	v.Algorithms = splitList(*algorithms)
+	v.RequiredHeaders = splitList(*require)
+	// Messages passed together are often from the same signer.
+	v.Cache = dkim.NewKeyCache()
+	if *resolver != "" {
+		v.Resolver = dkim.NewResolver(*resolver)
+	}
+
+	var key crypto.PublicKey
 	if *pubkey != "" {
 		keybytes, err := ioutil.ReadFile(*pubkey)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
-		dkey, err := dkim.DecodeDNSTXT(string(keybytes))
+		rec, err := dkim.ParseKeyRecord(string(keybytes))
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
-		key = dkey
+		key = rec
 	}
 	var files []string
 	if args := flag.Args(); len(args) > 0 {
 		files = args
 	}
+	if *filter {
+		if *ar == "" {
+			fmt.Fprintln(os.Stderr, "-filter requires an authserv-id from -ar")
+			os.Exit(1)
+		}
+		if len(files) > 0 {
+			fmt.Fprintln(os.Stderr, "-filter only reads from stdin")
+			os.Exit(1)
+		}
+		r := dkim.NormalizeReader(os.Stdin)
+		if *unstuff {
+			r.Unstuff()
+		}
+		if err := filterMessage(v, r, key, *timeout, *ar, *rename, *nl); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	out := output{hd: *hd, hdprefix: *hdprefix, hdsuffix: *hdsuffix, ar: *ar}
 	var numfails int
 	if len(files) > 0 {
 		for _, f := range files {
 			fd, err := os.Open(f)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
+				numfails++
+				continue
 			}
-			file, err := dkim.FileBuffer(dkim.NormalizeReader(fd))
-			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
+			r := dkim.NormalizeReader(fd)
+			if *unstuff {
+				r.Unstuff()
 			}
-
-			if err := dkim.VerifyWithPublicKey(file, key); err != nil || *hd != "" {
-				printResult(*hd, *hdprefix, *hdsuffix, f, err)
+			results, err := verify(v, r, key, *timeout)
+			if !out.printResults(f, results, err) {
 				numfails++
 			}
-			file.Close()
 			fd.Close()
-			if err := os.Remove(file.Name()); err != nil {
-				fmt.Fprintln(os.Stderr, err)
-			}
-
 		}
 	} else {
-		file, err := dkim.FileBuffer(dkim.NormalizeReader(os.Stdin))
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
+		r := dkim.NormalizeReader(os.Stdin)
+		if *unstuff {
+			r.Unstuff()
 		}
-		if err := dkim.VerifyWithPublicKey(file, key); err != nil || *hd != "" {
-			printResult(*hd, *hdprefix, *hdsuffix, "<stdin>", err)
+		results, err := verify(v, r, key, *timeout)
+		if !out.printResults("<stdin>", results, err) {
 			numfails++
 		}
-		if err := os.Remove(file.Name()); err != nil {
-			fmt.Fprintln(os.Stderr, err)
+	}
+	os.Exit(numfails)
+}
+
+// verify verifies the message in r with v, limiting DNS lookups to
+// timeout if it's not 0.
+// splitList splits a colon separated list from a flag, dropping any
+// empty entries so that an empty flag is an empty list.
+func splitList(s string) []string {
+	list := []string{}
+	for _, e := range strings.Split(s, ":") {
+		if e != "" {
+			list = append(list, e)
 		}
+	}
+	return list
+}
 
+func verify(v *dkim.Verifier, r io.Reader, key crypto.PublicKey, timeout time.Duration) ([]dkim.Result, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
-	os.Exit(numfails)
+	return v.VerifyAllContext(ctx, r, key)
 }
 
-// Helper to print the results for either stdin or per file.
-func printResult(hd, hdprefix, hdsuffix string, filename string, err error) {
-	if hd != "" {
-		if err == nil {
-			fmt.Printf("%v: %vPass%v\n", hd, hdprefix, hdsuffix)
-		} else if errstr := err.Error(); errstr == "Permanent failure: no DKIM signature" || strings.Index(errstr, "Temporary failure") >= 0 {
+// filterMessage verifies the message in r and prints it to stdout with
+// an Authentication-Results header for authservID added. Any existing
+// ones from authservID are removed, or renamed to rename if it's set.
+func filterMessage(v *dkim.Verifier, r io.Reader, key crypto.PublicKey, timeout time.Duration, authservID, rename string, unix bool) error {
+	// The message has to be kept to print it after the header, which
+	// depends on the whole message.
+	var msg bytes.Buffer
+	results, err := verify(v, io.TeeReader(r, &msg), key, timeout)
+	if _, cerr := io.Copy(&msg, r); cerr != nil {
+		return cerr
+	}
+	if err != nil && !errors.Is(err, dkim.ErrNoSignature) {
+		// The message still needs to be delivered, so report the
+		// error and let the header say why it couldn't be verified.
+		fmt.Fprintf(os.Stderr, "<stdin>: %v\n", err)
+	}
+	var out bytes.Buffer
+	header := dkim.AuthenticationResults(authservID, results, err)
+	if err := dkim.ReplaceAuthenticationResults(&msg, &out, header, rename); err != nil {
+		return err
+	}
+	if unix {
+		_, err = os.Stdout.Write(bytes.Replace(out.Bytes(), []byte("\r\n"), []byte("\n"), -1))
+		return err
+	}
+	_, err = out.WriteTo(os.Stdout)
+	return err
+}
+
+// output controls how the results are printed.
+type output struct {
+	hd, hdprefix, hdsuffix string
+	ar                     string
+}
+
+// Helper to print the results for either stdin or per file. It returns
+// true if at least one signature passed with a key that's not in testing
+// mode.
+func (o output) printResults(filename string, results []dkim.Result, err error) bool {
+	var pass, temp, revoked, authoritative bool
+	for _, res := range results {
+		if res.Testing {
+			// Treated the same as an unsigned message.
+			continue
+		}
+		authoritative = true
+		switch res.Status {
+		case dkim.Pass:
+			pass = true
+		case dkim.TempError:
+			temp = true
+		}
+		if errors.Is(res.Err, dkim.ErrKeyRevoked) {
+			revoked = true
+		}
+	}
+	if o.ar != "" {
+		if err != nil && !errors.Is(err, dkim.ErrNoSignature) {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", filename, err)
+		}
+		header := dkim.AuthenticationResults(o.ar, results, err)
+		fmt.Println(strings.Replace(header, "\r\n", "\n", -1))
+		return pass
+	}
+	if o.hd != "" {
+		if pass {
+			fmt.Printf("%v: %vPass%v\n", o.hd, o.hdprefix, o.hdsuffix)
+		} else if err != nil || temp || !authoritative {
 			// Nothing
+		} else if revoked {
+			fmt.Printf("%v: %vFail%v (key revoked)\n", o.hd, o.hdprefix, o.hdsuffix)
 		} else {
-			fmt.Printf("%v: %vFail%v\n", hd, hdprefix, hdsuffix)
+			fmt.Printf("%v: %vFail%v\n", o.hd, o.hdprefix, o.hdsuffix)
 		}
-	} else {
+		return pass
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v: %v\n", filename, err)
+		return false
 	}
-
+	for _, res := range results {
+		if res.Err != nil {
+			fmt.Fprintf(os.Stderr, "%v: d=%v s=%v a=%v: %v (%v)\n", filename, res.Domain, res.Selector, res.Algorithm, res.Status, res.Err)
+		} else {
+			fmt.Fprintf(os.Stderr, "%v: d=%v s=%v a=%v: %v\n", filename, res.Domain, res.Selector, res.Algorithm, res.Status)
+		}
+		if res.Testing {
+			fmt.Fprintf(os.Stderr, "%v: d=%v: key is in testing mode, result is not authoritative\n", filename, res.Domain)
+		}
+		if res.Status == dkim.Pass && res.UnsignedBodyBytes > 0 {
+			fmt.Fprintf(os.Stderr, "%v: d=%v: %d bytes of body not covered by signature\n", filename, res.Domain, res.UnsignedBodyBytes)
+		}
+	}
+	return pass
 }
@@ -0,0 +1,98 @@
+package dkim
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// The example message and keys from RFC 8463, Appendix A.
+const rfc8463Message = `DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;
+ d=football.example.com; i=@football.example.com;
+ q=dns/txt; s=brisbane; t=1528637909; h=from : to :
+ subject : date : message-id : from : subject : date;
+ bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;
+ b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus
+ Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==
+From: Joe SixPack <joe@football.example.com>
+To: Suzie Q <suzie@shopping.example.net>
+Subject: Is dinner ready?
+Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)
+Message-ID: <20030712040037.46341.5F8J@football.example.com>
+
+Hi.
+
+We lost the game.  Are you hungry yet?
+
+Joe.
+`
+
+const (
+	rfc8463PrivateKey = "nWGxne/9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A="
+	rfc8463DNSRecord  = "v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="
+)
+
+func TestEd25519Verify(t *testing.T) {
+	key, err := DecodeDNSTXT(rfc8463DNSRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := key.(ed25519.PublicKey); !ok {
+		t.Fatalf("Unexpected key type %T", key)
+	}
+	msg := strings.Replace(rfc8463Message, "\n", "\r\n", -1)
+	res, err := VerifyResult(strings.NewReader(msg), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Algorithm != "ed25519-sha256" || res.Status != Pass {
+		t.Errorf("Unexpected result %v", res)
+	}
+
+	// Ensure that a modified message fails.
+	msg = strings.Replace(msg, "Is dinner ready?", "Is lunch ready?", 1)
+	if _, err := VerifyResult(strings.NewReader(msg), key); err == nil {
+		t.Error("Modified message was verified")
+	}
+}
+
+func TestEd25519Sign(t *testing.T) {
+	seed, err := base64.StdEncoding.DecodeString(rfc8463PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := ed25519.NewKeyFromSeed(seed)
+	pub, err := DecodeDNSTXT(rfc8463DNSRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewSignature("relaxed/relaxed", "brisbane", "football.example.com", []string{"From", "To", "Subject"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Algorithm = "ed25519-sha256"
+
+	// Strip the existing signature and sign it ourselves.
+	msg := strings.Replace(rfc8463Message, "\n", "\r\n", -1)
+	msg = msg[strings.Index(msg, "From:"):]
+	var signed bytes.Buffer
+	if err := SignMessage(s, strings.NewReader(msg), &signed, key, "\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	res, err := VerifyResult(bytes.NewReader(signed.Bytes()), pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Signature.BodyHash != "2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=" {
+		t.Errorf("Unexpected body hash %v", res.Signature.BodyHash)
+	}
+
+	// An RSA algorithm with an Ed25519 key is an error.
+	s.Algorithm = "rsa-sha256"
+	if err := SignMessage(s, strings.NewReader(msg), &signed, key, "\r\n"); err == nil {
+		t.Error("Signed rsa-sha256 with an Ed25519 key")
+	}
+}
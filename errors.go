@@ -0,0 +1,168 @@
+package dkim
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Reason is the reason that a DKIM signature failed to verify.
+type Reason int
+
+const (
+	// The message has no DKIM-Signature header.
+	ReasonNoSignature Reason = iota + 1
+	// The DKIM-Signature header or key record is malformed.
+	ReasonSyntax
+	// The signing algorithm or canonicalization is not supported.
+	ReasonUnknownAlgorithm
+	// The body hash of the message does not match the bh= tag.
+	ReasonBodyHashMismatch
+	// The signature in the b= tag does not verify.
+	ReasonSignatureMismatch
+	// The domain of the i= tag is not the signing domain or a subdomain
+	// of it.
+	ReasonIdentityMismatch
+	// The signature has expired.
+	ReasonExpired
+	// The timestamp of the signature is in the future.
+	ReasonFutureTimestamp
+	// There is no public key for the selector.
+	ReasonKeyNotFound
+	// The public key has been revoked.
+	ReasonKeyRevoked
+	// The key record doesn't allow the key to be used for the signature.
+	ReasonKeyRestricted
+	// The DNS query for the public key timed out.
+	ReasonDNSTimeout
+	// The DNS query for the public key failed for another reason.
+	ReasonDNSError
+	// The signature is not acceptable to the verifier's policy, for
+	// instance because the key is too short.
+	ReasonPolicy
+)
+
+func (r Reason) String() string {
+	switch r {
+	case ReasonNoSignature:
+		return "no DKIM signature"
+	case ReasonSyntax:
+		return "syntax error"
+	case ReasonUnknownAlgorithm:
+		return "unknown algorithm"
+	case ReasonBodyHashMismatch:
+		return "body hash does not match"
+	case ReasonSignatureMismatch:
+		return "signature does not verify"
+	case ReasonIdentityMismatch:
+		return "identity does not match signing domain"
+	case ReasonExpired:
+		return "signature expired"
+	case ReasonFutureTimestamp:
+		return "signature timestamp is in the future"
+	case ReasonKeyNotFound:
+		return "no public key found"
+	case ReasonKeyRevoked:
+		return "key revoked"
+	case ReasonKeyRestricted:
+		return "key not allowed for signature"
+	case ReasonDNSTimeout:
+		return "DNS timeout"
+	case ReasonDNSError:
+		return "DNS error"
+	case ReasonPolicy:
+		return "not allowed by policy"
+	}
+	return "unknown reason"
+}
+
+// An Error is an error verifying (or signing) a DKIM signature. It is
+// either a permanent failure (PERMFAIL in RFC 6376), which will not succeed
+// if retried, or a temporary failure (TEMPFAIL), which may.
+//
+// Errors can be checked with errors.Is against ErrPermFail or ErrTempFail
+// to find the class of failure, or against one of the Err variables for a
+// specific reason. errors.As can be used to get the Error itself.
+type Error struct {
+	Reason    Reason
+	Temporary bool
+
+	// Message describes the error in more detail. If empty, the
+	// description of Reason is used.
+	Message string
+
+	// Err is the underlying error that caused this one, if any.
+	Err error
+}
+
+var (
+	// ErrPermFail matches any permanent failure.
+	ErrPermFail = errors.New("permanent failure")
+	// ErrTempFail matches any temporary failure.
+	ErrTempFail = errors.New("temporary failure")
+
+	// Each of these matches any Error with the same Reason.
+	ErrNoSignature       = &Error{Reason: ReasonNoSignature}
+	ErrSyntax            = &Error{Reason: ReasonSyntax}
+	ErrUnknownAlgorithm  = &Error{Reason: ReasonUnknownAlgorithm}
+	ErrBodyHashMismatch  = &Error{Reason: ReasonBodyHashMismatch}
+	ErrSignatureMismatch = &Error{Reason: ReasonSignatureMismatch}
+	ErrIdentityMismatch  = &Error{Reason: ReasonIdentityMismatch}
+	ErrExpired           = &Error{Reason: ReasonExpired}
+	ErrFutureTimestamp   = &Error{Reason: ReasonFutureTimestamp}
+	ErrKeyNotFound       = &Error{Reason: ReasonKeyNotFound}
+	// ErrKeyRevoked is returned when a key record has an empty p= tag,
+	// which means that the key has been revoked.
+	ErrKeyRevoked    = &Error{Reason: ReasonKeyRevoked}
+	ErrKeyRestricted = &Error{Reason: ReasonKeyRestricted}
+	ErrDNSTimeout    = &Error{Reason: ReasonDNSTimeout, Temporary: true}
+	ErrDNSError      = &Error{Reason: ReasonDNSError, Temporary: true}
+	ErrPolicy        = &Error{Reason: ReasonPolicy}
+)
+
+func (e *Error) Error() string {
+	if e.Temporary {
+		return "Temporary failure: " + e.description()
+	}
+	return "Permanent failure: " + e.description()
+}
+
+// description describes the error without saying whether it's temporary.
+func (e *Error) description() string {
+	msg := e.Message
+	if msg == "" {
+		msg = e.Reason.String()
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether e matches target. An Error matches ErrPermFail or
+// ErrTempFail depending on whether it's temporary, and any other *Error
+// with the same Reason.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrPermFail:
+		return !e.Temporary
+	case ErrTempFail:
+		return e.Temporary
+	}
+	t, ok := target.(*Error)
+	return ok && t.Reason == e.Reason
+}
+
+// permFail returns a permanent failure for reason, with the message
+// formatted from format and args.
+func permFail(reason Reason, format string, args ...interface{}) error {
+	return &Error{Reason: reason, Message: fmt.Sprintf(format, args...)}
+}
+
+// tempFail returns a temporary failure for reason, caused by err.
+func tempFail(reason Reason, err error) error {
+	return &Error{Reason: reason, Temporary: true, Err: err}
+}
@@ -0,0 +1,133 @@
+package dkim
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestErrorMatching(t *testing.T) {
+	_, versionErr := ParseKeyRecord("v=DKIM2; p=")
+	_, noKeyErr := ParseKeyRecord("v=DKIM1; k=rsa")
+	_, keyDataErr := ParseKeyRecord("v=DKIM1; p=AB!C")
+	_, keyTypeErr := ParseKeyRecord("v=DKIM1; k=dsa; p=AAAA")
+	_, canonErr := NewSignature("strict/strict", "foo", "example.com", nil)
+	tests := []struct {
+		err      error
+		matches  []error
+		excludes []error
+		message  string
+	}{
+		{
+			ErrNoSignature,
+			[]error{ErrPermFail, ErrNoSignature},
+			[]error{ErrTempFail, ErrKeyRevoked},
+			"Permanent failure: no DKIM signature",
+		},
+		{
+			permFail(ReasonKeyRestricted, "key may not be used for email"),
+			[]error{ErrPermFail, ErrKeyRestricted},
+			[]error{ErrTempFail, ErrKeyNotFound},
+			"Permanent failure: key may not be used for email",
+		},
+		{
+			tempFail(ReasonDNSTimeout, fmt.Errorf("i/o timeout")),
+			[]error{ErrTempFail, ErrDNSTimeout},
+			[]error{ErrPermFail, ErrDNSError},
+			"Temporary failure: DNS timeout: i/o timeout",
+		},
+		// Wrapped errors still match.
+		{
+			fmt.Errorf("message 1: %w", ErrBodyHashMismatch),
+			[]error{ErrPermFail, ErrBodyHashMismatch},
+			[]error{ErrTempFail, ErrSignatureMismatch},
+			"message 1: Permanent failure: body hash does not match",
+		},
+		// Errors parsing key records and signature parameters.
+		{
+			versionErr,
+			[]error{ErrPermFail, ErrSyntax},
+			[]error{ErrTempFail, ErrKeyNotFound},
+			"Permanent failure: unsupported key record version DKIM2",
+		},
+		{
+			noKeyErr,
+			[]error{ErrPermFail, ErrSyntax},
+			[]error{ErrTempFail, ErrKeyRevoked},
+			"Permanent failure: key record is missing required tag p=",
+		},
+		{
+			keyDataErr,
+			[]error{ErrPermFail, ErrSyntax},
+			[]error{ErrTempFail},
+			"Permanent failure: invalid public key data: illegal base64 data at input byte 2",
+		},
+		{
+			keyTypeErr,
+			[]error{ErrPermFail, ErrUnknownAlgorithm},
+			[]error{ErrTempFail, ErrSyntax},
+			"Permanent failure: unsupported key type dsa",
+		},
+		{
+			canonErr,
+			[]error{ErrPermFail, ErrSyntax},
+			[]error{ErrTempFail, ErrUnknownAlgorithm},
+			"Permanent failure: bad canonicalization strict/strict",
+		},
+	}
+	for i, tc := range tests {
+		for _, target := range tc.matches {
+			if !errors.Is(tc.err, target) {
+				t.Errorf("Case %d: %v does not match %v", i, tc.err, target)
+			}
+		}
+		for _, target := range tc.excludes {
+			if errors.Is(tc.err, target) {
+				t.Errorf("Case %d: %v unexpectedly matches %v", i, tc.err, target)
+			}
+		}
+		if tc.err.Error() != tc.message {
+			t.Errorf("Case %d: got message %q want %q", i, tc.err.Error(), tc.message)
+		}
+		var e *Error
+		if !errors.As(tc.err, &e) {
+			t.Errorf("Case %d: could not get *Error from %v", i, tc.err)
+		}
+	}
+}
+
+func TestVerifyErrorReasons(t *testing.T) {
+	s, err := NewSignature("relaxed/relaxed", "foo", "example.com", []string{"From", "Subject"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := signTestMessage(t, s, testMessage)
+
+	tests := []struct {
+		msg    string
+		reason Reason
+		status Status
+	}{
+		{testMessage, ReasonNoSignature, PermError},
+		{strings.Replace(msg, "a test message", "a modified message", 1), ReasonBodyHashMismatch, Fail},
+		{strings.Replace(msg, "I am a test", "I am modified", 1), ReasonSignatureMismatch, Fail},
+		{strings.Replace(msg, "v=1;", "v=2;", 1), ReasonSyntax, PermError},
+		{strings.Replace(msg, "a=rsa-sha256", "a=rsa-md5", 1), ReasonUnknownAlgorithm, PermError},
+		{strings.Replace(msg, "s=foo;", "s=foo; i=user@example.org;", 1), ReasonIdentityMismatch, PermError},
+	}
+	for i, tc := range tests {
+		res, err := VerifyResult(strings.NewReader(tc.msg), &testKey.PublicKey)
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Errorf("Case %d: unexpected error %v", i, err)
+			continue
+		}
+		if e.Reason != tc.reason {
+			t.Errorf("Case %d: got reason %v want %v", i, e.Reason, tc.reason)
+		}
+		if tc.reason != ReasonNoSignature && res.Status != tc.status {
+			t.Errorf("Case %d: got status %v want %v", i, res.Status, tc.status)
+		}
+	}
+}
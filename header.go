@@ -1,6 +1,7 @@
 package dkim
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"io"
@@ -9,46 +10,122 @@ import (
 
 var HeaderEnd = fmt.Errorf("End of mail headers")
 
-var headerEndRE *regexp.Regexp = regexp.MustCompile("\r\n[^\t \n]")
+var headerEndRE *regexp.Regexp = regexp.MustCompile("\r\n[^\t ]")
+
+// DefaultMaxHeaderLength is the maximum length in bytes of a single
+// (possibly folded) header field, unless a Verifier or Signer sets its own
+// MaxHeaderLength. Longer header fields cause an error rather than being
+// read into memory.
+const DefaultMaxHeaderLength = 1024 * 1024
 
 func readRawHeader(r io.ReadSeeker) (raw []byte, err error) {
-	buf := make([]byte, 8192)
 	// Take a bookmark so that we can seek back to the start of the
 	// header for the next read.
 	start, err := r.Seek(0, io.SeekCurrent)
 	if err != nil {
 		return nil, err
 	}
-	n, err := r.Read(buf)
-	if err != io.EOF && err != nil {
-		return nil, err
-	}
-	if err == io.EOF && n == 0 {
-		return nil, io.EOF
+	var buf []byte
+	chunk := make([]byte, 8192)
+	for {
+		n, err := r.Read(chunk)
+		if err != io.EOF && err != nil {
+			return nil, err
+		}
+		// Start searching just before the new data, in case the
+		// line ending was split between reads.
+		from := len(buf) - 2
+		if from < 0 {
+			from = 0
+		}
+		buf = append(buf, chunk[:n]...)
+		if re := headerEndRE.FindIndex(buf[from:]); re != nil {
+			if from+re[0] == 0 {
+				// There were 2 consecutive line ends, marking the
+				// end of the header section, so seek to the start
+				// of the body after the blank line.
+				if _, err := r.Seek(int64(start)+2, io.SeekStart); err != nil {
+					return nil, err
+				}
+				return nil, HeaderEnd
+			}
+			end := from + re[1] - 1
+			if end > DefaultMaxHeaderLength {
+				return nil, errHeaderTooLong(DefaultMaxHeaderLength)
+			}
+			// Seek back over anything we overread, so that the
+			// next call starts at the right place.
+			if _, err := r.Seek(int64(start)+int64(end), io.SeekStart); err != nil {
+				return nil, err
+			}
+			return buf[:end], nil
+		}
+		if err == io.EOF {
+			if len(buf) == 0 {
+				return nil, io.EOF
+			}
+			// There was no line ending, so just return what we
+			// read and assume it's the end of the headers.
+			return buf, nil
+		}
+		if len(buf) > DefaultMaxHeaderLength {
+			return nil, errHeaderTooLong(DefaultMaxHeaderLength)
+		}
 	}
-	buf = buf[:n]
-	re := headerEndRE.FindIndex(buf)
-	if re == nil {
-		// There was no line ending, so just return what we read and assume
-		// it's the end of the headers.
-		return buf, nil
+}
+
+// readBufferedHeader reads a single (possibly folded) header field from r
+// in the same way as readRawHeader, but without needing to seek back over
+// anything it read too far. It returns HeaderEnd after reading the blank
+// line that ends the header section, leaving r at the start of the body.
+// Header fields longer than max bytes are an error, or longer than
+// DefaultMaxHeaderLength if max is 0. If max is negative, there's no limit.
+func readBufferedHeader(r *bufio.Reader, max int) (raw []byte, err error) {
+	if max == 0 {
+		max = DefaultMaxHeaderLength
 	}
-	if re[0] == 0 {
-		// There were 2 consecutive line ends, marking the end of the
-		// header section, so seek to the start of the body after the
-		// blank line.
-		if _, err := r.Seek(int64(start)+2, io.SeekStart); err != nil {
+	for {
+		line, err := r.ReadSlice('\n')
+		raw = append(raw, line...)
+		if max > 0 && len(raw) > max {
+			return nil, errHeaderTooLong(max)
+		}
+		switch err {
+		case nil:
+		case bufio.ErrBufferFull:
+			continue
+		case io.EOF:
+			if len(raw) == 0 {
+				return nil, io.EOF
+			}
+			return raw, nil
+		default:
 			return nil, err
 		}
-		return nil, HeaderEnd
-	}
-	end := re[1] - 1
-	// Seek back over anything we overread, so that the next call
-	// starts at the right place.
-	if _, err := r.Seek(int64(start)+int64(end), io.SeekStart); err != nil {
-		return nil, err
+		if string(raw) == "\r\n" {
+			return nil, HeaderEnd
+		}
+		if !bytes.HasSuffix(raw, []byte("\r\n")) {
+			continue
+		}
+		// The header is folded if the next line starts with
+		// whitespace.
+		next, err := r.Peek(1)
+		if err == io.EOF {
+			return raw, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if next[0] != ' ' && next[0] != '\t' {
+			return raw, nil
+		}
 	}
-	return buf[:end], nil
+}
+
+// errHeaderTooLong returns the error for a header field longer than max
+// bytes.
+func errHeaderTooLong(max int) error {
+	return permFail(ReasonSyntax, "header field longer than %d bytes", max)
 }
 
 func ReadSMTPHeaderSimple(r io.ReadSeeker) (raw, converted []byte, err error) {
@@ -1,6 +1,10 @@
 package dkim
 
 import (
+	"bufio"
+	"errors"
+	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -88,3 +92,86 @@ func TestRelaxedHeader(t *testing.T) {
 		}
 	}
 }
+
+func TestLongHeader(t *testing.T) {
+	// A References header folded over many lines, long enough to span
+	// several reads.
+	var refs []string
+	for i := 0; i < 400; i++ {
+		refs = append(refs, fmt.Sprintf("<message-%d@example.com>", i))
+	}
+	references := "References: " + strings.Join(refs, "\r\n ") + "\r\n"
+	// A single unfolded line that's longer than a read.
+	to := "To: " + strings.Repeat("a", 20000) + "@example.com\r\n"
+
+	msg := "From: foo@example.com\r\n" + references + to + "Subject: Long\r\n\r\nBody\r\n"
+	r := strings.NewReader(msg)
+	for i, expected := range []string{"From: foo@example.com\r\n", references, to, "Subject: Long\r\n"} {
+		raw, _, err := ReadSMTPHeaderSimple(r)
+		if err != nil {
+			t.Fatalf("Header %d: %v", i, err)
+		}
+		if string(raw) != expected {
+			t.Errorf("Header %d: got %d bytes want %d", i, len(raw), len(expected))
+		}
+	}
+	if _, _, err := ReadSMTPHeaderSimple(r); err != HeaderEnd {
+		t.Errorf("Got %v want %v", err, HeaderEnd)
+	}
+	body, err := ReadSMTPBodySimple(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "Body\r\n" {
+		t.Errorf("Unexpected body %q", body)
+	}
+
+	// Headers longer than the limit are an error, not silently split.
+	br := bufio.NewReader(strings.NewReader(msg))
+	for i := 0; i < 3; i++ {
+		if _, err := readBufferedHeader(br, 16384); err != nil {
+			if i != 2 || !errors.Is(err, ErrSyntax) {
+				t.Errorf("Header %d: unexpected error %v", i, err)
+			}
+			break
+		} else if i == 2 {
+			t.Error("Header longer than the limit did not return an error")
+		}
+	}
+	long := "To: " + strings.Repeat("a", DefaultMaxHeaderLength) + "\r\n\r\n"
+	if _, _, err := ReadSMTPHeaderSimple(strings.NewReader(long)); !errors.Is(err, ErrSyntax) {
+		t.Errorf("Header longer than DefaultMaxHeaderLength: got %v want syntax error", err)
+	}
+}
+
+func TestHeaderFolding(t *testing.T) {
+	tests := []string{
+		"Subject: a\r\n folded\r\n\tline\r\nTo: b\r\n\r\nBody\r\n",
+		// A bare LF after a line isn't whitespace, so it doesn't
+		// continue the header.
+		"Subject: a\r\n\nTo: b\r\n\r\nBody\r\n",
+		"Subject: a\r\nTo: b",
+	}
+	for i, msg := range tests {
+		var raw, buffered []string
+		r := strings.NewReader(msg)
+		for {
+			h, _, err := ReadSMTPHeaderSimple(r)
+			if err != nil {
+				break
+			}
+			raw = append(raw, string(h))
+		}
+		br := bufio.NewReader(strings.NewReader(msg))
+		for {
+			h, err := readBufferedHeader(br, 0)
+			if err != nil {
+				break
+			}
+			buffered = append(buffered, string(h))
+		}
+		if !reflect.DeepEqual(raw, buffered) {
+			t.Errorf("Case %d: readers disagree: got %q and %q", i, raw, buffered)
+		}
+	}
+}
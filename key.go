@@ -0,0 +1,236 @@
+package dkim
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// A KeyRecord is a DKIM public key record, as published in the DNS at
+// selector._domainkey.domain and described in RFC 6376 section 3.6.1.
+type KeyRecord struct {
+	// The version of the record (the v= tag.) If present, it must be
+	// "DKIM1".
+	Version string
+
+	// HashAlgorithms is the list of hash algorithms (such as "sha256")
+	// which may be used with the key (the h= tag.) If empty, all
+	// algorithms are allowed.
+	HashAlgorithms []string
+
+	// KeyType is the type of the key (the k= tag), either "rsa" or
+	// "ed25519". If empty, it's assumed to be "rsa".
+	KeyType string
+
+	// Notes is human readable text about the key (the n= tag.)
+	Notes string
+
+	// PublicKey is the decoded public key from the p= tag. It's either
+	// an *rsa.PublicKey or an ed25519.PublicKey, or nil if the key has
+	// been revoked.
+	PublicKey crypto.PublicKey
+
+	// Services is the list of service types that the key may be used
+	// for (the s= tag.) If empty, it may be used for all services.
+	Services []string
+
+	// Flags is the list of flags (the t= tag), such as "y" for testing
+	// mode or "s" for strict subdomain mode.
+	Flags []string
+}
+
+// splitList splits a colon separated tag value into its trimmed
+// components.
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ":") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// ParseKeyRecord parses the DKIM key record txt.
+func ParseKeyRecord(txt string) (*KeyRecord, error) {
+	var rec KeyRecord
+	var p []byte
+	var hasp bool
+	tags, err := parseTagList([]byte(txt))
+	if err != nil {
+		return nil, &Error{Reason: ReasonSyntax, Message: "invalid key record", Err: err}
+	}
+	for _, tag := range tags {
+		value := tag.Value
+		switch tag.Name {
+		case "v":
+			if value != "DKIM1" {
+				return nil, permFail(ReasonSyntax, "unsupported key record version %v", value)
+			}
+			rec.Version = value
+		case "h":
+			rec.HashAlgorithms = splitList(value)
+		case "k":
+			rec.KeyType = value
+		case "n":
+			rec.Notes = value
+		case "p":
+			decoded, err := base64.StdEncoding.DecodeString(whitespaceRE.ReplaceAllString(value, ""))
+			if err != nil {
+				return nil, &Error{Reason: ReasonSyntax, Message: "invalid public key data", Err: err}
+			}
+			p = decoded
+			hasp = true
+		case "s":
+			rec.Services = splitList(value)
+		case "t":
+			rec.Flags = splitList(value)
+		}
+	}
+	if !hasp {
+		return nil, permFail(ReasonSyntax, "key record is missing required tag p=")
+	}
+	if len(p) == 0 {
+		return &rec, nil
+	}
+	switch rec.keyType() {
+	case "rsa":
+		key, err := x509.ParsePKIXPublicKey(p)
+		if err != nil {
+			return nil, &Error{Reason: ReasonSyntax, Message: "invalid public key data", Err: err}
+		}
+		c, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, permFail(ReasonSyntax, "public key is not an RSA key")
+		}
+		rec.PublicKey = c
+	case "ed25519":
+		// RFC 8463 publishes the raw public key, not an ASN.1
+		// structure.
+		if len(p) != ed25519.PublicKeySize {
+			return nil, permFail(ReasonSyntax, "invalid ed25519 key")
+		}
+		rec.PublicKey = ed25519.PublicKey(p)
+	default:
+		return nil, permFail(ReasonUnknownAlgorithm, "unsupported key type %v", rec.KeyType)
+	}
+	return &rec, nil
+}
+
+func (k KeyRecord) keyType() string {
+	if k.KeyType == "" {
+		return "rsa"
+	}
+	return k.KeyType
+}
+
+// String returns the key record in the DNS TXT record syntax.
+func (k KeyRecord) String() string {
+	ret := "v=DKIM1"
+	if len(k.HashAlgorithms) > 0 {
+		ret += fmt.Sprintf("; h=%v", strings.Join(k.HashAlgorithms, ":"))
+	}
+	ret += fmt.Sprintf("; k=%v", k.keyType())
+	if k.Notes != "" {
+		ret += fmt.Sprintf("; n=%v", k.Notes)
+	}
+	if len(k.Services) > 0 {
+		ret += fmt.Sprintf("; s=%v", strings.Join(k.Services, ":"))
+	}
+	if len(k.Flags) > 0 {
+		ret += fmt.Sprintf("; t=%v", strings.Join(k.Flags, ":"))
+	}
+	var p []byte
+	switch key := k.PublicKey.(type) {
+	case *rsa.PublicKey:
+		p, _ = x509.MarshalPKIXPublicKey(key)
+	case ed25519.PublicKey:
+		p = key
+	}
+	ret += fmt.Sprintf("; p=%v", base64.StdEncoding.EncodeToString(p))
+	return ret
+}
+
+func (k KeyRecord) hasFlag(flag string) bool {
+	for _, f := range k.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// Testing returns true if the key is in testing mode (t=y), in which case
+// signatures made with it should not be treated differently from unsigned
+// messages.
+func (k KeyRecord) Testing() bool {
+	return k.hasFlag("y")
+}
+
+// Strict returns true if the key's t=s flag is set, in which case the
+// domain of the i= tag of a signature must be exactly the signing domain,
+// not a subdomain of it.
+func (k KeyRecord) Strict() bool {
+	return k.hasFlag("s")
+}
+
+// check ensures that the signature sig is allowed to be verified with the
+// key k, according to the restrictions in the record.
+func (k KeyRecord) check(sig *Signature) error {
+	keytype, hash := "rsa", sig.Algorithm
+	if split := strings.SplitN(sig.Algorithm, "-", 2); len(split) == 2 {
+		keytype, hash = split[0], split[1]
+	}
+	if keytype != k.keyType() {
+		return permFail(ReasonKeyRestricted, "key type %v does not match algorithm %v", k.keyType(), sig.Algorithm)
+	}
+	if len(k.HashAlgorithms) > 0 {
+		allowed := false
+		for _, h := range k.HashAlgorithms {
+			if h == hash {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return permFail(ReasonKeyRestricted, "hash algorithm %v not allowed by key", hash)
+		}
+	}
+	if len(k.Services) > 0 {
+		allowed := false
+		for _, s := range k.Services {
+			if s == "*" || s == "email" {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return permFail(ReasonKeyRestricted, "key may not be used for email")
+		}
+	}
+	if k.Strict() && sig.Identity != "" {
+		idomain := sig.Identity[strings.LastIndex(sig.Identity, "@")+1:]
+		if !strings.EqualFold(idomain, sig.Domain) {
+			return permFail(ReasonKeyRestricted, "key does not allow subdomain identity %v", sig.Identity)
+		}
+	}
+	return nil
+}
+
+// DecodeDNSTXT decodes the public key from the DKIM key record txt. The key
+// returned is either an *rsa.PublicKey or an ed25519.PublicKey, depending
+// on the k= tag of the record.
+//
+// Use ParseKeyRecord to get the rest of the record.
+func DecodeDNSTXT(txt string) (crypto.PublicKey, error) {
+	rec, err := ParseKeyRecord(txt)
+	if err != nil {
+		return nil, err
+	}
+	if rec.PublicKey == nil {
+		return nil, ErrKeyRevoked
+	}
+	return rec.PublicKey, nil
+}
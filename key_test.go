@@ -0,0 +1,155 @@
+package dkim
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseKeyRecord(t *testing.T) {
+	tests := []struct {
+		txt      string
+		keytype  string
+		hashes   []string
+		services []string
+		flags    []string
+		notes    string
+		valid    bool
+	}{
+		{
+			"v=DKIM1; k=rsa; p=MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEA1Kd87/UeJjenpabgbFwh+eBCsSTrqmwIYYvywlbhbqoo2DymndFkbjOVIPIldNs/m40KF+yzMn1skyoxcTUGCQs8g3FgD2Ap3ZB5DekAo5wMmk4wimDO+U8QzI3SD07y2+07wlNWwIt8svnxgdxGkVbbhzY8i+RQ9DpSVpPbF7ykQxtKXkv/ahW3KjViiAH+ghvvIhkx4xYSIc9oSwVmAl5OctMEeWUwg8Istjqz8BZeTWbf41fbNhte7Y+YqZOwq1Sd0DbvYAD9NOZK9vlfuac0598HY+vtSBczUiKERHv1yRbcaQtZFh5wtiRrN04BLUTD21MycBX5jYchHjPY/wIDAQAB",
+			"rsa", nil, nil, nil, "", true,
+		},
+		{
+			rfc8463DNSRecord,
+			"ed25519", nil, nil, nil, "", true,
+		},
+		{
+			"v=DKIM1; h=sha256 : sha1; k=ed25519; n=A note; s=email; t=y:s; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo=",
+			"ed25519", []string{"sha256", "sha1"}, []string{"email"}, []string{"y", "s"}, "A note", true,
+		},
+		// Wrong version
+		{"v=DKIM2; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo=", "", nil, nil, nil, "", false},
+		// Unknown key type
+		{"v=DKIM1; k=dsa; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo=", "", nil, nil, nil, "", false},
+		// Missing p=
+		{"v=DKIM1; k=rsa", "", nil, nil, nil, "", false},
+		// Invalid base64
+		{"v=DKIM1; k=rsa; p=!!!", "", nil, nil, nil, "", false},
+	}
+	for i, tc := range tests {
+		rec, err := ParseKeyRecord(tc.txt)
+		if !tc.valid {
+			if err == nil {
+				t.Errorf("Case %d: invalid record was parsed", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Case %d: %v", i, err)
+			continue
+		}
+		switch tc.keytype {
+		case "rsa":
+			if _, ok := rec.PublicKey.(*rsa.PublicKey); !ok {
+				t.Errorf("Case %d: got key %T want RSA", i, rec.PublicKey)
+			}
+		case "ed25519":
+			if _, ok := rec.PublicKey.(ed25519.PublicKey); !ok {
+				t.Errorf("Case %d: got key %T want Ed25519", i, rec.PublicKey)
+			}
+		}
+		if strings.Join(rec.HashAlgorithms, ":") != strings.Join(tc.hashes, ":") ||
+			strings.Join(rec.Services, ":") != strings.Join(tc.services, ":") ||
+			strings.Join(rec.Flags, ":") != strings.Join(tc.flags, ":") ||
+			rec.Notes != tc.notes {
+			t.Errorf("Case %d: unexpected record %v", i, rec)
+		}
+
+		// Ensure that the record round trips.
+		rec2, err := ParseKeyRecord(rec.String())
+		if err != nil {
+			t.Errorf("Case %d: could not reparse %v: %v", i, rec.String(), err)
+			continue
+		}
+		if rec.String() != rec2.String() {
+			t.Errorf("Case %d: got %v want %v", i, rec2.String(), rec.String())
+		}
+	}
+}
+
+func TestKeyRecordRestrictions(t *testing.T) {
+	msg := strings.Replace(rfc8463Message, "\n", "\r\n", -1)
+	tests := []struct {
+		txt     string
+		status  Status
+		testing bool
+	}{
+		{rfc8463DNSRecord, Pass, false},
+		{rfc8463DNSRecord + "; h=sha256", Pass, false},
+		{rfc8463DNSRecord + "; h=sha1", PermError, false},
+		{rfc8463DNSRecord + "; s=email", Pass, false},
+		{rfc8463DNSRecord + "; s=*", Pass, false},
+		{rfc8463DNSRecord + "; s=other", PermError, false},
+		{rfc8463DNSRecord + "; t=y", Pass, true},
+		// i= is the same as d=, so strict mode passes.
+		{rfc8463DNSRecord + "; t=s", Pass, false},
+	}
+	for i, tc := range tests {
+		rec, err := ParseKeyRecord(tc.txt)
+		if err != nil {
+			t.Fatalf("Case %d: %v", i, err)
+		}
+		res, _ := VerifyResult(strings.NewReader(msg), rec)
+		if res.Status != tc.status {
+			t.Errorf("Case %d: got %v (%v) want %v", i, res.Status, res.Err, tc.status)
+		}
+		if res.Testing != tc.testing {
+			t.Errorf("Case %d: got testing %v want %v", i, res.Testing, tc.testing)
+		}
+	}
+
+	// A subdomain identity fails in strict mode.
+	sig := &Signature{Algorithm: "ed25519-sha256", Domain: "example.com", Identity: "user@mail.example.com"}
+	rec := KeyRecord{KeyType: "ed25519", Flags: []string{"s"}}
+	if err := rec.check(sig); err == nil {
+		t.Error("Subdomain identity allowed by strict key")
+	}
+	rec.Flags = nil
+	if err := rec.check(sig); err != nil {
+		t.Errorf("Subdomain identity not allowed by non-strict key: %v", err)
+	}
+	// The key type must match the algorithm.
+	rec.KeyType = "rsa"
+	if err := rec.check(sig); err == nil {
+		t.Error("RSA key allowed for ed25519-sha256")
+	}
+}
+
+func TestRevokedKey(t *testing.T) {
+	const revoked = "v=DKIM1; k=ed25519; p="
+	if _, err := DecodeDNSTXT(revoked); !errors.Is(err, ErrKeyRevoked) {
+		t.Errorf("DecodeDNSTXT: got %v want %v", err, ErrKeyRevoked)
+	}
+	rec, err := ParseKeyRecord(revoked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.PublicKey != nil {
+		t.Fatalf("Revoked record has key %v", rec.PublicKey)
+	}
+	if rec.String() != revoked {
+		t.Errorf("Revoked record: got %v want %v", rec.String(), revoked)
+	}
+
+	msg := strings.Replace(rfc8463Message, "\n", "\r\n", -1)
+	res, err := VerifyResult(strings.NewReader(msg), rec)
+	if !errors.Is(err, ErrKeyRevoked) {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if res.Status != PermError {
+		t.Errorf("Unexpected status %v", res.Status)
+	}
+}
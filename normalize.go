@@ -21,7 +21,10 @@ type normalizeReader struct {
 	// Any leftover bytes after doing the normalization from the last
 	// call.
 	leftOver []byte
-	eof      bool
+	// Bytes read from the underlying reader which haven't been
+	// normalized yet.
+	pending []byte
+	eof     bool
 
 	unstuff bool
 }
@@ -31,54 +34,53 @@ func (n *normalizeReader) Unstuff() {
 	n.unstuff = true
 }
 func (n *normalizeReader) Read(r []byte) (int, error) {
-	var err error
-	// If there's already enough from the last read to fill this buffer,
-	// don't bother with the read.
-	if len(n.leftOver) < len(r)/2 {
-		// There wasn't enough data, so append it to the scratch
-		// buffer
-		var j int
-		j, err = n.Reader.Read(r)
-		n.leftOver = append(n.leftOver, r[:j]...)
+	if len(r) == 0 {
+		return 0, nil
+	}
+	for len(n.leftOver) == 0 && !n.eof {
+		buf := make([]byte, len(r))
+		j, err := n.Reader.Read(buf)
+		raw := append(n.pending, buf[:j]...)
+		n.pending = nil
 		if err == io.EOF {
-			//println("EOF")
 			n.eof = true
+		} else if err != nil {
+			return 0, err
 		}
-	}
-
-	if len(n.leftOver) == 0 && n.eof {
-		return 0, io.EOF
-	}
-	// Replace all non-normalized lines remaining with \r\n
-	//	n.leftOver = nonnormalLineRE.ReplaceAll(n.leftOver, []byte{'$', '1', '\r', '\n'})
-	n.leftOver = bytes.Replace(n.leftOver, []byte{'\r', '\n'}, []byte{'\n'}, -1)
-	n.leftOver = bytes.Replace(n.leftOver, []byte{'\r'}, []byte{'\n'}, -1)
-	n.leftOver = bytes.Replace(n.leftOver, []byte{'\n'}, []byte{'\r', '\n'}, -1)
-	if n.unstuff {
-		n.leftOver = bytes.Replace(n.leftOver, []byte{'\n', '.'}, []byte{'\n'}, -1)
-	}
-	// If there's up to len(r), fill up r and return the amount that
-	// was read.
-	if len(n.leftOver) <= len(r) {
-		size := len(n.leftOver)
-		for i := range n.leftOver {
-			r[i] = n.leftOver[i]
+		if !n.eof {
+			// Hold back a line ending at the end of what was
+			// read, since the rest of it (or a dot to unstuff)
+			// may come with the next read.
+			hold := 0
+			switch {
+			case n.unstuff && bytes.HasSuffix(raw, []byte{'\r', '\n'}):
+				hold = 2
+			case bytes.HasSuffix(raw, []byte{'\r'}):
+				hold = 1
+			case n.unstuff && bytes.HasSuffix(raw, []byte{'\n'}):
+				hold = 1
+			}
+			n.pending = append(n.pending, raw[len(raw)-hold:]...)
+			raw = raw[:len(raw)-hold]
 		}
-		n.leftOver = nil
-		if n.eof {
-			return size, io.EOF
+
+		// Replace all non-normalized line endings with \r\n
+		raw = bytes.Replace(raw, []byte{'\r', '\n'}, []byte{'\n'}, -1)
+		raw = bytes.Replace(raw, []byte{'\r'}, []byte{'\n'}, -1)
+		raw = bytes.Replace(raw, []byte{'\n'}, []byte{'\r', '\n'}, -1)
+		if n.unstuff {
+			raw = bytes.Replace(raw, []byte{'\n', '.'}, []byte{'\n'}, -1)
 		}
-		return size, err
+		n.leftOver = raw
 	}
-
-	// If there was more than len(r), fill up what we can and store
-	// the rest n.leftOver
-	for i := range r {
-		r[i] = n.leftOver[i]
+	if len(n.leftOver) == 0 {
+		return 0, io.EOF
 	}
-	n.leftOver = r[len(r):]
-	return len(r), err
 
+	// Fill up what we can of r and keep the rest for the next call.
+	size := copy(r, n.leftOver)
+	n.leftOver = n.leftOver[size:]
+	return size, nil
 }
 
 func FileBuffer(r io.Reader) (*os.File, error) {
@@ -92,5 +94,5 @@ func FileBuffer(r io.Reader) (*os.File, error) {
 }
 
 func NormalizeReader(r io.Reader) *normalizeReader {
-	return &normalizeReader{r, nil, false, false}
+	return &normalizeReader{Reader: r}
 }
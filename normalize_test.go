@@ -0,0 +1,52 @@
+package dkim
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeReader(t *testing.T) {
+	tests := []struct {
+		in, expected string
+		unstuff      bool
+	}{
+		{"foo\nbar\n", "foo\r\nbar\r\n", false},
+		{"foo\r\nbar\rbaz\r\n", "foo\r\nbar\r\nbaz\r\n", false},
+		{strings.Repeat("\n", 100), strings.Repeat("\r\n", 100), false},
+		{"foo\n..bar\n.\n", "foo\r\n.bar\r\n\r\n", true},
+	}
+	for i, tc := range tests {
+		// Read through a small buffer so that line endings are split
+		// between reads.
+		for _, size := range []int{1, 2, 3, 7, 4096} {
+			n := NormalizeReader(strings.NewReader(tc.in))
+			if tc.unstuff {
+				n.Unstuff()
+			}
+			var got []byte
+			buf := make([]byte, size)
+			for {
+				c, err := n.Read(buf)
+				got = append(got, buf[:c]...)
+				if err != nil {
+					break
+				}
+			}
+			if string(got) != tc.expected {
+				t.Errorf("Case %d (size %d): got %q want %q", i, size, got, tc.expected)
+			}
+		}
+	}
+}
+
+func TestNormalizeReaderEmptyRead(t *testing.T) {
+	n := NormalizeReader(strings.NewReader("foo\n"))
+	if c, err := n.Read(nil); c != 0 || err != nil {
+		t.Errorf("Read(nil): got %d, %v want 0, nil", c, err)
+	}
+	buf := make([]byte, 16)
+	c, _ := n.Read(buf)
+	if got := string(buf[:c]); got != "foo\r\n" {
+		t.Errorf("Read after empty read: got %q want %q", got, "foo\r\n")
+	}
+}
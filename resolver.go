@@ -0,0 +1,63 @@
+package dkim
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// A Resolver looks up the DNS TXT records that DKIM public keys are
+// published in. *net.Resolver implements Resolver, and net.DefaultResolver
+// is used if a Verifier doesn't have one.
+//
+// If name doesn't exist, LookupTXT should return a *net.DNSError with
+// IsNotFound set, so that it can be told apart from a temporary failure.
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// NewResolver returns a Resolver which sends all of its queries to the DNS
+// server at addr, which is a host and port such as "127.0.0.1:53".
+func NewResolver(addr string) Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// lookupKey looks up the key record at loc with the resolver r.
+func lookupKey(ctx context.Context, r Resolver, loc string) (*KeyRecord, error) {
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	txt, err := r.LookupTXT(ctx, loc)
+	if err != nil {
+		var dnserr *net.DNSError
+		switch {
+		case errors.As(err, &dnserr) && dnserr.IsNotFound:
+			return nil, &Error{Reason: ReasonKeyNotFound, Err: err}
+		case errors.As(err, &dnserr) && dnserr.IsTimeout, errors.Is(err, context.DeadlineExceeded):
+			return nil, tempFail(ReasonDNSTimeout, err)
+		}
+		return nil, tempFail(ReasonDNSError, err)
+	}
+	revoked := false
+	for _, entry := range txt {
+		rec, err := ParseKeyRecord(entry)
+		if err != nil {
+			continue
+		}
+		if rec.PublicKey == nil {
+			revoked = true
+			continue
+		}
+		return rec, nil
+	}
+	if revoked {
+		return nil, ErrKeyRevoked
+	}
+	return nil, ErrKeyNotFound
+}
@@ -0,0 +1,81 @@
+package dkim
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mapResolver serves TXT records from memory.
+type mapResolver map[string][]string
+
+func (m mapResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	txt, ok := m[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return txt, nil
+}
+
+// slowResolver never answers before ctx is done.
+type slowResolver struct{}
+
+func (slowResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestResolver(t *testing.T) {
+	pub, err := x509.MarshalPKIXPublicKey(&testKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSignature("relaxed/relaxed", "foo", "example.com", []string{"From", "Subject"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := signTestMessage(t, s, testMessage)
+
+	record := "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(pub)
+	tests := []struct {
+		resolver Resolver
+		status   Status
+		err      error
+	}{
+		{mapResolver{"foo._domainkey.example.com": {record}}, Pass, nil},
+		// Records that aren't key records are skipped.
+		{mapResolver{"foo._domainkey.example.com": {"v=spf1 -all", record}}, Pass, nil},
+		{mapResolver{"bar._domainkey.example.com": {record}}, PermError, ErrKeyNotFound},
+		{mapResolver{"foo._domainkey.example.com": {"v=DKIM1; k=rsa; p="}}, PermError, ErrKeyRevoked},
+		{slowResolver{}, TempError, ErrDNSTimeout},
+	}
+	for i, tc := range tests {
+		v := NewVerifier()
+		v.Resolver = tc.resolver
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		res, err := v.VerifyResultContext(ctx, strings.NewReader(signed), nil)
+		cancel()
+		if res.Status != tc.status {
+			t.Errorf("Case %d: got %v want %v", i, res.Status, tc.status)
+		}
+		if tc.err == nil && err != nil {
+			t.Errorf("Case %d: unexpected error: %v", i, err)
+		} else if tc.err != nil && !errors.Is(err, tc.err) {
+			t.Errorf("Case %d: got error %v want %v", i, err, tc.err)
+		}
+	}
+
+	// A nil *KeyRecord means that the key should be looked up, the
+	// same as a nil key.
+	v := NewVerifier()
+	v.Resolver = mapResolver{"foo._domainkey.example.com": {record}}
+	var rec *KeyRecord
+	if res, err := v.VerifyResult(strings.NewReader(signed), rec); res.Status != Pass || err != nil {
+		t.Errorf("Nil *KeyRecord: got %v (%v) want pass", res.Status, err)
+	}
+}
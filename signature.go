@@ -5,18 +5,18 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"net"
 
 	"encoding/base64"
 
 	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha1"
 	"crypto/sha256"
-	"crypto/x509"
-	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Signature struct {
@@ -27,6 +27,108 @@ type Signature struct {
 	Headers                                      []string
 	BodyHash                                     string
 	Body                                         string
+
+	// Identity is the agent or user identifier (the i= tag) on whose
+	// behalf the message was signed, such as "user@example.com". Its
+	// domain must be the same as, or a subdomain of, Domain.
+	Identity string
+
+	// Length is the number of bytes of the canonicalized body that are
+	// covered by the signature (the l= tag.) If nil, the entire body
+	// is signed.
+	Length *int64
+
+	// Timestamp is the time the signature was created (the t= tag) and
+	// Expiration the time after which it should no longer be considered
+	// valid (the x= tag.) A zero time means the tag is not present.
+	Timestamp, Expiration time.Time
+
+	// Extra contains any other tags, such as q= or z=, so that they're
+	// preserved when the signature is printed.
+	Extra []Tag
+
+	// Oversign lists headers which are added to Headers one more time
+	// than they appear in the message when it's signed, so that the
+	// signature breaks if another one is added later. It isn't part of
+	// the signature itself.
+	Oversign []string
+
+	// If HeaderSelection is not nil, Headers is replaced by the headers
+	// it selects from the message when the message is signed.
+	HeaderSelection *HeaderSelection
+}
+
+// RecommendedHeaders are the headers that a HeaderSelection signs if
+// they're present, based on the list in RFC 6376 section 5.4.1 along with
+// the MIME headers that affect how the body is displayed.
+var RecommendedHeaders = []string{
+	"From", "Sender", "Reply-To", "Subject", "Date", "Message-ID",
+	"To", "Cc", "MIME-Version", "Content-Type",
+	"Content-Transfer-Encoding", "Content-ID", "Content-Description",
+	"Resent-Date", "Resent-From", "Resent-Sender", "Resent-To",
+	"Resent-Cc", "Resent-Message-ID", "In-Reply-To", "References",
+	"List-Id", "List-Help", "List-Unsubscribe", "List-Subscribe",
+	"List-Post", "List-Owner", "List-Archive",
+}
+
+// TransitHeaders are headers which are commonly added or changed while a
+// message is in transit, and so are never signed by a HeaderSelection
+// unless they're explicitly included.
+var TransitHeaders = []string{
+	"Return-Path", "Received", "DKIM-Signature", "Authentication-Results",
+	"ARC-Seal", "ARC-Message-Signature", "ARC-Authentication-Results",
+}
+
+// A HeaderSelection chooses which headers to sign based on the headers
+// in the message. It selects each of the RecommendedHeaders and Include
+// headers in the message, once for every time it appears. From is
+// always selected.
+type HeaderSelection struct {
+	// Include lists headers to sign if they're present, in addition
+	// to the recommended ones. It may include TransitHeaders.
+	Include []string
+
+	// Exclude lists headers which should not be signed, even if
+	// they're recommended or included.
+	Exclude []string
+}
+
+// selectHeaders returns the headers selected from msg by hs.
+func (hs HeaderSelection) selectHeaders(msg []Header) []string {
+	// names maps the lowercased name of each selected header to the
+	// name it's signed as.
+	names := make(map[string]string)
+	for _, h := range RecommendedHeaders {
+		names[strings.ToLower(h)] = h
+	}
+	for _, h := range TransitHeaders {
+		delete(names, strings.ToLower(h))
+	}
+	for _, h := range hs.Include {
+		names[strings.ToLower(h)] = h
+	}
+	for _, h := range hs.Exclude {
+		if lh := strings.ToLower(h); lh != "from" {
+			delete(names, lh)
+		}
+	}
+
+	// From is required, so it's signed even if the message doesn't
+	// have one.
+	headers := []string{"From"}
+	from := false
+	for _, h := range msg {
+		name, ok := names[h.name()]
+		if !ok {
+			continue
+		}
+		if h.name() == "from" && !from {
+			from = true
+			continue
+		}
+		headers = append(headers, name)
+	}
+	return headers
 }
 
 func (s Signature) String() string {
@@ -53,9 +155,24 @@ func (s Signature) String() string {
 	if s.Selector != "" {
 		ret += fmt.Sprintf("; s=%v", s.Selector)
 	}
+	if s.Identity != "" {
+		ret += fmt.Sprintf("; i=%v", s.Identity)
+	}
 	if len(s.Headers) > 0 {
 		ret += fmt.Sprintf("; h=%v", strings.Join(s.Headers, ":"))
 	}
+	if s.Length != nil {
+		ret += fmt.Sprintf("; l=%d", *s.Length)
+	}
+	if !s.Timestamp.IsZero() {
+		ret += fmt.Sprintf("; t=%d", s.Timestamp.Unix())
+	}
+	if !s.Expiration.IsZero() {
+		ret += fmt.Sprintf("; x=%d", s.Expiration.Unix())
+	}
+	for _, t := range s.Extra {
+		ret += fmt.Sprintf("; %v=%v", t.Name, t.Value)
+	}
 	if s.BodyHash != "" {
 		ret += fmt.Sprintf("; bh=%v", s.BodyHash)
 	}
@@ -65,6 +182,10 @@ func (s Signature) String() string {
 	return ret
 }
 
+// NewSignature returns a Signature for signing with the canonicalization
+// canon, and the selector and domain of the key. If headers is nil, the
+// headers to sign are chosen from the message when it's signed with the
+// default HeaderSelection.
 func NewSignature(canon string, selector, domain string, headers []string) (Signature, error) {
 	sig := Signature{
 		Version:                1,
@@ -75,6 +196,9 @@ func NewSignature(canon string, selector, domain string, headers []string) (Sign
 		Selector:               selector,
 		Headers:                headers,
 	}
+	if headers == nil {
+		sig.HeaderSelection = &HeaderSelection{}
+	}
 	switch canon {
 	case "simple/simple", "simple":
 		// nothing
@@ -88,52 +212,91 @@ func NewSignature(canon string, selector, domain string, headers []string) (Sign
 		sig.HeaderCanonicalization = "relaxed"
 		sig.BodyCanonicalization = "simple"
 	default:
-		return Signature{}, fmt.Errorf("Bad canonicalization")
+		return Signature{}, permFail(ReasonSyntax, "bad canonicalization %v", canon)
 	}
 	return sig, nil
 }
 
-func (s Signature) Sig() []byte {
-	decoded, err := base64.StdEncoding.DecodeString(s.Body)
-	if err != nil {
-		return nil
+// headersFor returns the headers signed by s for a message with the
+// headers msg. If s has a HeaderSelection, it's used to choose the
+// headers, and then each header in s.Oversign is listed one more time
+// than it appears in the message.
+func (s Signature) headersFor(msg []Header) []string {
+	if s.HeaderSelection != nil {
+		s.Headers = s.HeaderSelection.selectHeaders(msg)
+	}
+	if len(s.Oversign) == 0 {
+		return s.Headers
+	}
+	present := make(map[string]int)
+	for _, h := range msg {
+		present[h.name()]++
+	}
+	listed := make(map[string]int)
+	for _, h := range s.Headers {
+		listed[strings.ToLower(h)]++
+	}
+	headers := append([]string(nil), s.Headers...)
+	for _, h := range s.Oversign {
+		lh := strings.ToLower(h)
+		for ; listed[lh] <= present[lh]; listed[lh]++ {
+			headers = append(headers, h)
+		}
 	}
-	return decoded
+	return headers
 }
 
-type Tag struct {
-	Name, Value string
+// checkIdentity ensures that the domain of the i= tag is the same as the
+// signing domain or a subdomain of it, as required by RFC 6376 section 3.5.
+func (s Signature) checkIdentity() error {
+	if s.Identity == "" {
+		return nil
+	}
+	at := strings.LastIndex(s.Identity, "@")
+	if at < 0 {
+		return permFail(ReasonSyntax, "malformed identity %v", s.Identity)
+	}
+	idomain := strings.ToLower(s.Identity[at+1:])
+	domain := strings.ToLower(s.Domain)
+	if idomain != domain && !strings.HasSuffix(idomain, "."+domain) {
+		return permFail(ReasonIdentityMismatch, "identity domain %v does not match signing domain %v", idomain, domain)
+	}
+	return nil
 }
 
-func splitTags(s []byte) []Tag {
-	var tgs []Tag
-	tags := bytes.Split(s, []byte{';'})
-	for _, t := range tags {
-		if strings.TrimSpace(string(t)) == "" {
-			continue
-		}
-		splitb := bytes.SplitN(t, []byte{'='}, 2)
-		tgs = append(tgs, Tag{string(bytes.TrimSpace(splitb[0])), string(splitb[1])})
+func (s Signature) Sig() []byte {
+	decoded, err := base64.StdEncoding.DecodeString(s.Body)
+	if err != nil {
+		return nil
 	}
-	return tgs
+	return decoded
 }
 
-func ParseSignature(header []byte) *Signature {
+// ParseSignature parses the DKIM-Signature header field header. Tags which
+// are not otherwise understood are kept in Extra.
+func ParseSignature(header []byte) (*Signature, error) {
 	splith := bytes.SplitN(header, []byte{':'}, 2)
-	name := string(splith[0])
-	if strings.ToLower(name) != "dkim-signature" {
-		return nil
+	if len(splith) != 2 || !strings.EqualFold(strings.TrimSpace(string(splith[0])), "dkim-signature") {
+		return nil, permFail(ReasonSyntax, "not a DKIM-Signature header")
+	}
+	tags, err := parseTagList(splith[1])
+	if err != nil {
+		return nil, &Error{Reason: ReasonSyntax, Message: "invalid DKIM-Signature", Err: err}
+	}
+	// c= defaults to simple/simple if it's not present.
+	s := Signature{
+		HeaderCanonicalization: "simple",
+		BodyCanonicalization:   "simple",
 	}
-	tags := splitTags(splith[1])
-	var s Signature
+	seen := make(map[string]bool)
 	for _, t := range tags {
+		seen[t.Name] = true
 		switch t.Name {
 		case "v":
-			v, err := strconv.Atoi(t.Value)
-			if err != nil {
-				return nil
+			if t.Value != "1" {
+				return nil, permFail(ReasonSyntax, "unsupported DKIM-Signature version %v", t.Value)
 			}
-			s.Version = v
+			s.Version = 1
 		case "a":
 			s.Algorithm = t.Value
 		case "bh":
@@ -145,186 +308,245 @@ func ParseSignature(header []byte) *Signature {
 			case "simple", "simple/simple":
 				s.HeaderCanonicalization = "simple"
 				s.BodyCanonicalization = "simple"
-			case "relaxed", "relaxed/relaxed":
+			case "relaxed/relaxed":
 				s.HeaderCanonicalization = "relaxed"
 				s.BodyCanonicalization = "relaxed"
 			case "simple/relaxed":
 				s.HeaderCanonicalization = "simple"
 				s.BodyCanonicalization = "relaxed"
-			case "relaxed/simple":
+			case "relaxed", "relaxed/simple":
+				// A single value only names the header
+				// canonicalization, and the body defaults to
+				// simple (RFC 6376 section 3.5).
 				s.HeaderCanonicalization = "relaxed"
 				s.BodyCanonicalization = "simple"
 			default:
-				return nil
+				return nil, permFail(ReasonUnknownAlgorithm, "unknown canonicalization %v", t.Value)
 			}
 		case "d":
 			s.Domain = t.Value
 		case "h":
 			s.Headers = strings.Split(whitespaceRE.ReplaceAllString(t.Value, ""), ":")
+		case "l":
+			l, err := strconv.ParseInt(t.Value, 10, 64)
+			if err != nil || l < 0 {
+				return nil, permFail(ReasonSyntax, "invalid body length %v", t.Value)
+			}
+			s.Length = &l
 		case "s":
 			s.Selector = t.Value
-			// FIXME: Add i, l, q, t, x, z
+		case "i":
+			s.Identity = t.Value
+		case "t", "x":
+			v, err := strconv.ParseInt(t.Value, 10, 64)
+			if err != nil || v < 0 {
+				return nil, permFail(ReasonSyntax, "invalid %v= timestamp %v", t.Name, t.Value)
+			}
+			if t.Name == "t" {
+				s.Timestamp = time.Unix(v, 0)
+			} else {
+				s.Expiration = time.Unix(v, 0)
+			}
+		default:
+			s.Extra = append(s.Extra, t)
 		}
 	}
-	return &s
+	for _, name := range []string{"v", "a", "b", "bh", "d", "h", "s"} {
+		if !seen[name] {
+			return nil, permFail(ReasonSyntax, "DKIM-Signature is missing required tag %v=", name)
+		}
+	}
+	signsFrom := false
+	for _, h := range s.Headers {
+		if strings.EqualFold(h, "from") {
+			signsFrom = true
+		}
+	}
+	if !signsFrom {
+		return nil, permFail(ReasonSyntax, "DKIM-Signature does not sign the From header")
+	}
+	if !s.Timestamp.IsZero() && !s.Expiration.IsZero() && !s.Expiration.After(s.Timestamp) {
+		return nil, permFail(ReasonSyntax, "DKIM-Signature expires before its timestamp")
+	}
+	return &s, nil
 }
 
 type Header struct {
 	Raw, Relaxed []byte
 }
 
-// signatureBase calculates the basic parts of the DKIM signature
-// shared by both signing and verifying.
-//
-// It extracts the mail headers from r and returns them in a map along
-// with the signature. The bodyhash returned is already base64 encoded.
-//
-// Newlines must already be normalized to CRLF in r.
-//
-// If s is passed, it will be used as the DKIM signature (for signing), otherwise
-// the signature will be parsed from the message header (for verification). When
-// signing, sig.BodyHash will be populated, and when verifying, it will be compared
-func signatureBase(r io.ReadSeeker, s *Signature) (sig *Signature, msg, dkimheader []byte, err error) {
-	headers := make(map[string][]Header)
-	for raw, conv, err := ReadSMTPHeaderRelaxed(r); err == nil; raw, conv, err = ReadSMTPHeaderRelaxed(r) {
-		split := bytes.SplitN(conv, []byte{':'}, 2)
-		name := string(split[0])
-		// headers acts as an upside-down stack. We add the oldest ones
-		// to the start, and consume from the front in dkimMessageBase.
-		headers[name] = append([]Header{Header{raw, conv}}, headers[name]...)
-		if name == "dkim-signature" && s == nil {
-			sig = ParseSignature(raw)
+// name returns the lowercased name of the header h.
+func (h Header) name() string {
+	split := bytes.SplitN(h.Relaxed, []byte{':'}, 2)
+	return string(split[0])
+}
+
+// readBufferedHeaders reads all the headers from r in the order that they
+// appear in the message, leaving r at the start of the body. Header fields
+// longer than max bytes are an error, as with readBufferedHeader.
+func readBufferedHeaders(r *bufio.Reader, max int) ([]Header, error) {
+	var headers []Header
+	for {
+		raw, err := readBufferedHeader(r, max)
+		if err == HeaderEnd || err == io.EOF {
+			return headers, nil
 		}
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, Header{raw, relaxHeader(raw)})
 	}
-	cbody, err := ReadSMTPBodyRelaxed(r)
-	if err != nil {
-		return nil, nil, nil, err
-	}
-	sha := sha256.Sum256(cbody[:])
-	encoded := string(base64.StdEncoding.EncodeToString(sha[:]))
-	if s != nil {
-		sig = s
-		s.BodyHash = encoded
-		raw := []byte(s.String())
-		relax := relaxHeader(raw)
-		headers["dkim-signature"] = append([]Header{{raw, relax}}, headers["dkim-signature"]...)
-	}
-	if sig == nil {
-		return nil, nil, nil, fmt.Errorf("Permanent failure: no DKIM signature")
-	}
-	if encoded != sig.BodyHash {
-		return nil, nil, nil, fmt.Errorf("Permanent failure: body hash does not match")
+}
+
+// hashAlgorithm returns the hash used by the signing algorithm algorithm
+// (the a= tag) for both the body hash and the signature.
+func hashAlgorithm(algorithm string) (crypto.Hash, error) {
+	switch algorithm {
+	case "rsa-sha256", "sha256", "ed25519-sha256":
+		return crypto.SHA256, nil
+	case "rsa-sha1", "sha1":
+		return crypto.SHA1, nil
 	}
+	return 0, permFail(ReasonUnknownAlgorithm, "unknown algorithm %v", algorithm)
+}
 
-	var tohash []byte
+// signedHeaders returns the canonicalized headers from headers which are
+// covered by sig, in the order listed in the signature, along with the
+// canonicalized DKIM-Signature header dkimheader that they're signed by.
+func signedHeaders(headers []Header, sig *Signature, dkimheader Header) (msg, sighead []byte) {
+	// stack acts as an upside-down stack. We add the oldest ones
+	// to the start, and consume from the front.
+	stack := make(map[string][]Header)
+	for _, h := range headers {
+		name := h.name()
+		stack[name] = append([]Header{h}, stack[name]...)
+	}
 	for _, h := range sig.Headers {
 		var hval Header
 		lh := strings.ToLower(h)
-		if header, ok := headers[lh]; ok && len(header) > 0 {
+		if header, ok := stack[lh]; ok && len(header) > 0 {
 			// If there is a header, consume it so that if a header
 			// is included in sig.Headers multiple times the next
 			// one is correct.
 			hval = header[0]
-			headers[lh] = header[1:]
+			stack[lh] = header[1:]
 		}
 		switch sig.HeaderCanonicalization {
 		case "simple":
-			tohash = append(tohash, hval.Raw...)
+			msg = append(msg, hval.Raw...)
 		case "relaxed":
-			tohash = append(tohash, hval.Relaxed...)
+			msg = append(msg, hval.Relaxed...)
 		}
 	}
-	var sighead []byte
-	rawsig, ok := headers["dkim-signature"]
-	if !ok {
-		return nil, nil, nil, fmt.Errorf("Permanent failure: No DKIM-Signature")
-	}
 	if sig.HeaderCanonicalization == "relaxed" {
-		sighead = bytes.TrimRight(rawsig[0].Relaxed, "\r\n")
+		sighead = bytes.TrimRight(dkimheader.Relaxed, "\r\n")
 	} else {
-		sighead = bytes.TrimRight(rawsig[0].Raw, "\r\n")
+		sighead = bytes.TrimRight(dkimheader.Raw, "\r\n")
 	}
-	return sig, tohash, sighead, nil
-
+	return msg, sighead
 }
 
-var bRE = regexp.MustCompile("b=[^;]+")
+// verifyBase checks the parts of the signature sig from the DKIM-Signature
+// header dkimheader that can be verified without the public key, once the
+// body has been written to body. It returns the canonicalized headers and
+// DKIM-Signature header to be verified, in the same format as
+// signedHeaders, and the number of canonicalized body bytes which were
+// excluded by the signature's length limit.
+func verifyBase(headers []Header, body *bodyHasher, sig *Signature, dkimheader Header) (msg, sighead []byte, unsigned int64, err error) {
+	encoded, unsigned, err := body.Sum()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if encoded != sig.BodyHash {
+		return nil, nil, 0, ErrBodyHashMismatch
+	}
+	msg, sighead = signedHeaders(headers, sig, dkimheader)
+	return msg, sighead, unsigned, nil
+}
 
-func SignedHeader(s Signature, r io.ReadSeeker, dst io.Writer, key *rsa.PrivateKey, nl string) error {
+// SignedHeader signs the message in r in the same way as SignMessage, but
+// only writes the DKIM-Signature header to dst.
+func SignedHeader(s Signature, r io.Reader, dst io.Writer, key crypto.Signer, nl string) error {
 	if nl != "\n" {
 		nl = "\r\n"
 	}
-	sig, msg, basedkimsig, err := signatureBase(r, &s)
-	b, err := signDKIMMessage(msg, basedkimsig, s.Algorithm, key)
+	signer, err := NewSigner(s, key, nil)
 	if err != nil {
 		return err
 	}
-	sig.Body = b
-	fmt.Fprintf(dst, "%v%v", sig, nl)
+	if _, err := io.Copy(signer, r); err != nil {
+		return err
+	}
+	if err := signer.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(dst, "%v%v", signer.Signature(), nl)
 	return nil
 }
 
 // SignMessage signs the message in r with the signature parameters from s and
 // the private key key, writing the result with the added DKIM-Signature to
-// dst.
-func SignMessage(s Signature, r io.ReadSeeker, dst io.Writer, key *rsa.PrivateKey, nl string) error {
-	if nl != "\n" {
-		nl = "\r\n"
+// dst. If nl is "\n", the result is written with "\n" line endings instead
+// of "\r\n".
+//
+// Newlines in r must already be in CRLF format.
+func SignMessage(s Signature, r io.Reader, dst io.Writer, key crypto.Signer, nl string) error {
+	if nl == "\n" {
+		dst = &lfWriter{w: dst}
 	}
-	sig, msg, basedkimsig, err := signatureBase(r, &s)
-	b, err := signDKIMMessage(msg, basedkimsig, s.Algorithm, key)
+	signer, err := NewSigner(s, key, dst)
 	if err != nil {
 		return err
 	}
-	sig.Body = b
-	if _, err := r.Seek(0, io.SeekStart); err != nil {
+	if _, err := io.Copy(signer, r); err != nil {
 		return err
 	}
-	linescan := bufio.NewScanner(r)
-	addedSig := false
-	for linescan.Scan() {
-		if err := linescan.Err(); err != nil {
-			return err
-		}
-		line := linescan.Text()
-		if strings.HasPrefix(line, "From ") {
-			fmt.Fprintf(dst, "%v%v", line, nl)
-			continue
-		}
-		if !addedSig {
-			addedSig = true
-			fmt.Fprintf(dst, "%v%v", sig, nl)
-			fmt.Fprintf(dst, "%v%v", line, nl)
-		} else {
-			fmt.Fprintf(dst, "%v%v", line, nl)
-		}
-	}
-	return nil
+	return signer.Close()
 }
 
 // signDKIMMessage signs a message that has already been canonicalized according
 // to the DKIM standard.
-func signDKIMMessage(message, dkimsig []byte, algorithm string, key *rsa.PrivateKey) (b string, err error) {
-	dkimsig = bRE.ReplaceAll(dkimsig, []byte{'b', '='})
+//
+// key must have an RSA public key for the rsa algorithms, or an Ed25519
+// public key for ed25519-sha256. Since only the hash of the message is
+// passed to key, the private key itself can be held anywhere that a
+// crypto.Signer can reach, such as an HSM or another process.
+func signDKIMMessage(message, dkimsig []byte, algorithm string, key crypto.Signer) (b string, err error) {
+	dkimsig, err = stripSignature(dkimsig)
+	if err != nil {
+		return "", err
+	}
 	message = append(message, dkimsig...)
+	hash, err := hashAlgorithm(algorithm)
+	if err != nil {
+		return "", err
+	}
+	h := hash.New()
+	h.Write(message)
+	digest := h.Sum(nil)
+
+	var opts crypto.SignerOpts = hash
 	switch algorithm {
-	case "rsa-sha256", "sha256":
-		hash := sha256.Sum256(message)
-		v, err := rsa.SignPKCS1v15(nil, key, crypto.SHA256, hash[:])
-		if err != nil {
-			return "", err
+	case "rsa-sha256", "sha256", "rsa-sha1", "sha1":
+		if _, ok := key.Public().(*rsa.PublicKey); !ok {
+			return "", permFail(ReasonUnknownAlgorithm, "%v requires an RSA key", algorithm)
 		}
-		return base64.StdEncoding.EncodeToString(v), nil
-
-	case "rsa-sha1", "sha1":
-		hash := sha1.Sum(message)
-		v, err := rsa.SignPKCS1v15(nil, key, crypto.SHA1, hash[:])
-		if err != nil {
-			return "", err
+	case "ed25519-sha256":
+		if _, ok := key.Public().(ed25519.PublicKey); !ok {
+			return "", permFail(ReasonUnknownAlgorithm, "%v requires an Ed25519 key", algorithm)
 		}
-		return base64.StdEncoding.EncodeToString(v), nil
+		// RFC 8463 signs the SHA-256 hash of the message with
+		// PureEdDSA, so the hash is the message as far as the
+		// signer is concerned.
+		opts = crypto.Hash(0)
+	default:
+		return "", ErrUnknownAlgorithm
 	}
-	return "", fmt.Errorf("Permanent failure: unknown algorithm")
+	v, err := key.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(v), nil
 }
 
 // dkimVerify verifies that a message verifies with header of dkimsig and a
@@ -337,75 +559,69 @@ func signDKIMMessage(message, dkimsig []byte, algorithm string, key *rsa.Private
 // This function is mostly for testing with a known key. In general, you should use the
 // Verify function which does the same thing, but extracts the public key from the appropriate
 // place according to the dkimsig.
-func dkimVerify(message, dkimsig []byte, sig []byte, algorithm string, key *rsa.PublicKey) error {
-	dkimsig = bRE.ReplaceAll(dkimsig, []byte{'b', '='})
+func dkimVerify(message, dkimsig []byte, sig []byte, algorithm string, key crypto.PublicKey) error {
+	dkimsig, err := stripSignature(dkimsig)
+	if err != nil {
+		return err
+	}
 	message = append(message, dkimsig...)
 	switch algorithm {
 	case "rsa-sha256", "sha256":
+		rsakey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return permFail(ReasonKeyRestricted, "key type does not match algorithm %v", algorithm)
+		}
 		hash := sha256.Sum256(message)
-		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig)
+		if err := rsa.VerifyPKCS1v15(rsakey, crypto.SHA256, hash[:], sig); err != nil {
+			return &Error{Reason: ReasonSignatureMismatch, Err: err}
+		}
+		return nil
 	case "rsa-sha1", "sha1":
+		rsakey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return permFail(ReasonKeyRestricted, "key type does not match algorithm %v", algorithm)
+		}
 		hash := sha1.Sum(message)
-		return rsa.VerifyPKCS1v15(key, crypto.SHA1, hash[:], sig)
+		if err := rsa.VerifyPKCS1v15(rsakey, crypto.SHA1, hash[:], sig); err != nil {
+			return &Error{Reason: ReasonSignatureMismatch, Err: err}
+		}
+		return nil
+	case "ed25519-sha256":
+		edkey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return permFail(ReasonKeyRestricted, "key type does not match algorithm %v", algorithm)
+		}
+		hash := sha256.Sum256(message)
+		if !ed25519.Verify(edkey, hash[:], sig) {
+			return ErrSignatureMismatch
+		}
+		return nil
 	}
-	return fmt.Errorf("Permanent failure: unknown algorithm")
+	return ErrUnknownAlgorithm
 }
 
 // VerifyWithPublicKey verifies a reader r, but uses the passed public key
 // instead of trying to extract the key from the DNS.
-func VerifyWithPublicKey(r io.ReadSeeker, key *rsa.PublicKey) error {
-	sig, msg, sighead, err := signatureBase(r, nil)
-	if err != nil {
-		return err
-	}
-	if key == nil {
-		if key, err = lookupKeyFromDNS(sig.Selector + "._domainkey." + sig.Domain); err != nil {
-			return err
-		}
-	}
-	sighash, err := base64.StdEncoding.DecodeString(sig.Body)
-	if err != nil {
-		return fmt.Errorf("Permanent failure: could not decode body")
-	}
-	return dkimVerify(msg, sighead, sighash, sig.Algorithm, key)
+func VerifyWithPublicKey(r io.Reader, key crypto.PublicKey) error {
+	_, err := VerifyResult(r, key)
+	return err
+}
+
+// VerifyResult verifies a reader r in the same way as VerifyWithPublicKey, but
+// also returns details about the signature that was verified.
+func VerifyResult(r io.Reader, key crypto.PublicKey) (Result, error) {
+	return NewVerifier().VerifyResult(r, key)
+}
+
+// VerifyAll verifies every DKIM signature in the message from reader r
+// with the default Verifier, returning a result for each.
+func VerifyAll(r io.Reader) ([]Result, error) {
+	return NewVerifier().VerifyAll(r, nil)
 }
 
 // Verify verifies the message from reader r has a valid DKIM signature.
 //
 // Newlines in r must already be in CRLF format.
-func Verify(r io.ReadSeeker) error {
+func Verify(r io.Reader) error {
 	return VerifyWithPublicKey(r, nil)
 }
-
-func DecodeDNSTXT(txt string) (*rsa.PublicKey, error) {
-	// FIXME: This should check the k tag instead of assuming
-	// rsa
-	for _, tag := range splitTags([]byte(txt)) {
-		if tag.Name == "p" {
-			decoded, err := base64.StdEncoding.DecodeString(tag.Value)
-			if err != nil {
-				continue
-			}
-			key, err := x509.ParsePKIXPublicKey(decoded)
-			if err != nil {
-				continue
-			}
-			if c, ok := key.(*rsa.PublicKey); ok {
-				return c, nil
-			}
-		}
-	}
-	return nil, fmt.Errorf("No key found")
-}
-func lookupKeyFromDNS(loc string) (*rsa.PublicKey, error) {
-	txt, err := net.LookupTXT(loc)
-	if err != nil {
-		return nil, fmt.Errorf("Temporary failure: %v", err)
-	}
-	for _, entry := range txt {
-		if key, err := DecodeDNSTXT(entry); err == nil {
-			return key, nil
-		}
-	}
-	return nil, fmt.Errorf("Permanent error: no public key found")
-}
@@ -1,7 +1,10 @@
 package dkim
 
 import (
+	"errors"
+	"strings"
 	"testing"
+	"time"
 )
 
 func compareSignature(a, b Signature) bool {
@@ -23,12 +26,32 @@ func compareSignature(a, b Signature) bool {
 	if a.Selector != b.Selector {
 		return false
 	}
+	if a.Identity != b.Identity {
+		return false
+	}
 	if a.BodyHash != b.BodyHash {
 		return false
 	}
 	if a.Body != b.Body {
 		return false
 	}
+	if (a.Length == nil) != (b.Length == nil) {
+		return false
+	}
+	if a.Length != nil && *a.Length != *b.Length {
+		return false
+	}
+	if !a.Timestamp.Equal(b.Timestamp) || !a.Expiration.Equal(b.Expiration) {
+		return false
+	}
+	if len(a.Extra) != len(b.Extra) {
+		return false
+	}
+	for i := range a.Extra {
+		if a.Extra[i] != b.Extra[i] {
+			return false
+		}
+	}
 	if len(a.Headers) != len(b.Headers) {
 		return false
 	}
@@ -40,6 +63,7 @@ func compareSignature(a, b Signature) bool {
 	return true
 }
 func TestParseSignature(t *testing.T) {
+	length := int64(24)
 	tests := []struct {
 		Header   string
 		Expected Signature
@@ -67,15 +91,128 @@ func TestParseSignature(t *testing.T) {
 				Body:                   `JCDj28y8XYsO966hVa5ZEuWjfJE/X8+taTThyL2oSn+2ia76pc8sifMt1vJYqI6Pq/sy0gpVqrnB5DVOZjG2mkRpE+wnQTgChTFNBwGdlV1aMNIjvNzhRU4kEyAd9e4G7XzENPyH2tE9JFrap10ic5zb1WP4nl3ZPu8xg9+wuHg8GarD3cbmFhjJQgRf2bZ4yJA6NTgtTV+vt8AZYGV6+Ar6OQ+Jhhmto/fI3ISLyWiorfg/brJLhDdo68h88Hs/KME2Kzqm5yN5itrggEx7csYBYRQDDu9b8TdXU6Y5gSa4qHrDQtGmXpAFzeH/+N21pWnL6jdHZy5d70NvAAMJ3A==`,
 			},
 		},
+		{
+			`DKIM-Signature: v=1; a=rsa-sha256; c=simple; d=example.com; s=foo; i=user@example.com;
+		           h=from:subject; l=24; q=dns/txt; z=From:foo@example.com; t=1516811704; x=1516898104; bh=pgAiAFfTfUaEQnXZovg+sCMsEhi40aifwX3+V1TobJI=; b=`,
+			Signature{
+				Version:                1,
+				Algorithm:              "rsa-sha256",
+				HeaderCanonicalization: "simple",
+				BodyCanonicalization:   "simple",
+				Domain:                 "example.com",
+				Selector:               "foo",
+				Identity:               "user@example.com",
+				Headers:                []string{"from", "subject"},
+				BodyHash:               "pgAiAFfTfUaEQnXZovg+sCMsEhi40aifwX3+V1TobJI=",
+				Length:                 &length,
+				Timestamp:              time.Unix(1516811704, 0),
+				Expiration:             time.Unix(1516898104, 0),
+				Extra:                  []Tag{{"q", "dns/txt"}, {"z", "From:foo@example.com"}},
+			},
+		},
+		{
+			// c=relaxed only sets the header canonicalization.
+			`DKIM-Signature: v=1; a=rsa-sha256; c=relaxed; d=example.com; s=foo; h=from; bh=pgAiAFfTfUaEQnXZovg+sCMsEhi40aifwX3+V1TobJI=; b=`,
+			Signature{
+				Version:                1,
+				Algorithm:              "rsa-sha256",
+				HeaderCanonicalization: "relaxed",
+				BodyCanonicalization:   "simple",
+				Domain:                 "example.com",
+				Selector:               "foo",
+				Headers:                []string{"from"},
+				BodyHash:               "pgAiAFfTfUaEQnXZovg+sCMsEhi40aifwX3+V1TobJI=",
+			},
+		},
 	}
 	for i, tc := range tests {
-		got := ParseSignature([]byte(tc.Header))
-		if got == nil {
-			t.Errorf("Case %d: got nil", i)
+		got, err := ParseSignature([]byte(tc.Header))
+		if err != nil {
+			t.Errorf("Case %d: %v", i, err)
 			continue
 		}
 		if !compareSignature(*got, tc.Expected) {
 			t.Errorf("Case %d: got %v want %v", i, *got, tc.Expected)
 		}
+
+		// Ensure that the signature round trips, including any
+		// unknown tags.
+		got2, err := ParseSignature([]byte(got.String()))
+		if err != nil {
+			t.Errorf("Case %d: could not reparse %v: %v", i, got.String(), err)
+			continue
+		}
+		if !compareSignature(*got2, tc.Expected) {
+			t.Errorf("Case %d: got %v want %v after round trip", i, *got2, tc.Expected)
+		}
+	}
+}
+
+func TestParseInvalidSignature(t *testing.T) {
+	const valid = "v=1; a=rsa-sha256; d=example.com; s=foo; h=from:to; bh=AAAA; b=AAAA"
+	tests := []string{
+		// Not a DKIM-Signature
+		"Subject: " + valid,
+		valid,
+		// Syntax errors
+		"DKIM-Signature: " + valid + "; foo",
+		"DKIM-Signature: " + valid + "; a=rsa-sha1",
+		// Missing required tags
+		"DKIM-Signature: a=rsa-sha256; d=example.com; s=foo; h=from:to; bh=AAAA; b=AAAA",
+		"DKIM-Signature: v=1; d=example.com; s=foo; h=from:to; bh=AAAA; b=AAAA",
+		"DKIM-Signature: v=1; a=rsa-sha256; d=example.com; s=foo; h=from:to; bh=AAAA",
+		"DKIM-Signature: v=1; a=rsa-sha256; d=example.com; s=foo; h=from:to; b=AAAA",
+		"DKIM-Signature: v=1; a=rsa-sha256; s=foo; h=from:to; bh=AAAA; b=AAAA",
+		"DKIM-Signature: v=1; a=rsa-sha256; d=example.com; s=foo; bh=AAAA; b=AAAA",
+		"DKIM-Signature: v=1; a=rsa-sha256; d=example.com; h=from:to; bh=AAAA; b=AAAA",
+		// Unsupported version
+		"DKIM-Signature: v=2; a=rsa-sha256; d=example.com; s=foo; h=from:to; bh=AAAA; b=AAAA",
+		// From isn't signed
+		"DKIM-Signature: v=1; a=rsa-sha256; d=example.com; s=foo; h=to:subject; bh=AAAA; b=AAAA",
+		// Invalid values
+		"DKIM-Signature: " + valid + "; c=foo",
+		"DKIM-Signature: " + valid + "; l=-1",
+		"DKIM-Signature: " + valid + "; t=abc",
+		"DKIM-Signature: " + valid + "; t=100; x=50",
+	}
+	for i, tc := range tests {
+		if sig, err := ParseSignature([]byte(tc)); err == nil {
+			t.Errorf("Case %d: invalid signature parsed as %v", i, sig)
+		}
+	}
+	if _, err := ParseSignature([]byte("DKIM-Signature: " + valid)); err != nil {
+		t.Errorf("Valid signature did not parse: %v", err)
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	tests := []struct {
+		identity, domain string
+		valid            bool
+	}{
+		{"", "example.com", true},
+		{"user@example.com", "example.com", true},
+		{"@example.com", "example.com", true},
+		{"user@Mail.Example.com", "example.com", true},
+		{"user@example.org", "example.com", false},
+		{"user@notexample.com", "example.com", false},
+		{"user@example.com", "mail.example.com", false},
+		{"example.com", "example.com", false},
+	}
+	for i, tc := range tests {
+		s := Signature{Domain: tc.domain, Identity: tc.identity}
+		err := s.checkIdentity()
+		if tc.valid && err != nil {
+			t.Errorf("Case %d: unexpected error: %v", i, err)
+		} else if !tc.valid && err == nil {
+			t.Errorf("Case %d: identity %v was accepted for %v", i, tc.identity, tc.domain)
+		}
+	}
+
+	// Ensure a mismatched identity in a message is a failure when
+	// verifying.
+	msg := "DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=foo; i=user@example.org; h=from; bh=pgAiAFfTfUaEQnXZovg+sCMsEhi40aifwX3+V1TobJI=; b=\r\nFrom: user@example.org\r\n\r\nThis is a test message\r\n"
+	if _, err := VerifyResult(strings.NewReader(msg), &testKey.PublicKey); !errors.Is(err, ErrIdentityMismatch) {
+		t.Errorf("Unexpected error for mismatched identity: %v", err)
 	}
 }
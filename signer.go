@@ -0,0 +1,187 @@
+package dkim
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"fmt"
+	"io"
+)
+
+var errSignerClosed = fmt.Errorf("Signer is already closed")
+
+// A Signer signs a message that is written to it, and writes the message
+// with the new DKIM-Signature header added to a destination when it's
+// closed.
+//
+// The header section is buffered until the blank line that ends it, but
+// the body is canonicalized and hashed as it's written. Since the
+// DKIM-Signature header needs to come before the message, a Signer with a
+// destination also has to keep the message until it's closed. A Signer
+// without a destination only keeps the headers, and the caller can add
+// the header from Signature to its own copy of the message.
+//
+// Newlines written to a Signer must already be in CRLF format.
+type Signer struct {
+	// MaxHeaderLength is the maximum length in bytes of a single header
+	// field in the message. If 0, DefaultMaxHeaderLength is used. It
+	// must be set before the message is written.
+	MaxHeaderLength int
+
+	sig Signature
+	key crypto.Signer
+	dst io.Writer
+
+	// header is the raw header section, including any mbox "From "
+	// lines before it. envelope is the length of those lines.
+	header   []byte
+	envelope int
+	headers  []Header
+
+	inBody bool
+	body   *bodyHasher
+
+	// The message as written, if it's going to be written to dst.
+	message bytes.Buffer
+	closed  bool
+}
+
+// NewSigner returns a Signer which signs a message with the signature
+// parameters from s and the private key key, which may be an
+// *rsa.PrivateKey or ed25519.PrivateKey, or any other crypto.Signer with
+// an RSA or Ed25519 public key. When the Signer is closed, the signed
+// message is written to dst, unless dst is nil.
+func NewSigner(s Signature, key crypto.Signer, dst io.Writer) (*Signer, error) {
+	if err := s.checkIdentity(); err != nil {
+		return nil, err
+	}
+	body, err := newBodyHasher(&s)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{sig: s, key: key, dst: dst, body: body}, nil
+}
+
+// Write writes part of the message to the Signer.
+func (s *Signer) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errSignerClosed
+	}
+	if s.dst != nil {
+		s.message.Write(p)
+	}
+	if s.inBody {
+		return s.body.Write(p)
+	}
+
+	// Look for the blank line that ends the header section, starting
+	// far enough back to find one that was split between writes.
+	from := len(s.header) - 3
+	if from < 0 {
+		from = 0
+	}
+	s.header = append(s.header, p...)
+	end := -1
+	if bytes.HasPrefix(s.header, []byte("\r\n")) {
+		end = 2
+	} else if i := bytes.Index(s.header[from:], []byte("\r\n\r\n")); i >= 0 {
+		end = from + i + 4
+	}
+	if end < 0 {
+		return len(p), nil
+	}
+	body := s.header[end:]
+	s.header = s.header[:end]
+	if err := s.readHeaders(); err != nil {
+		return 0, err
+	}
+	s.inBody = true
+	s.body.Write(body)
+	return len(p), nil
+}
+
+// readHeaders parses the buffered header section, skipping over any mbox
+// "From " lines at the start of it.
+func (s *Signer) readHeaders() error {
+	for bytes.HasPrefix(s.header[s.envelope:], []byte("From ")) {
+		i := bytes.IndexByte(s.header[s.envelope:], '\n')
+		if i < 0 {
+			break
+		}
+		s.envelope += i + 1
+	}
+	headers, err := readBufferedHeaders(bufio.NewReader(bytes.NewReader(s.header[s.envelope:])), s.MaxHeaderLength)
+	if err != nil {
+		return err
+	}
+	s.headers = headers
+	return nil
+}
+
+// Close finishes signing the message. If the Signer has a destination,
+// the DKIM-Signature header and message are written to it.
+func (s *Signer) Close() error {
+	if s.closed {
+		return errSignerClosed
+	}
+	s.closed = true
+	if !s.inBody {
+		// The message had no body.
+		if err := s.readHeaders(); err != nil {
+			return err
+		}
+	}
+
+	s.sig.Headers = s.sig.headersFor(s.headers)
+	var err error
+	if s.sig.BodyHash, _, err = s.body.Sum(); err != nil {
+		return err
+	}
+	raw := []byte(s.sig.String())
+	msg, sighead := signedHeaders(s.headers, &s.sig, Header{raw, relaxHeader(raw)})
+	if s.sig.Body, err = signDKIMMessage(msg, sighead, s.sig.Algorithm, s.key); err != nil {
+		return err
+	}
+	if s.dst == nil {
+		return nil
+	}
+
+	message := s.message.Bytes()
+	if _, err := s.dst.Write(message[:s.envelope]); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.dst, "%v\r\n", s.sig); err != nil {
+		return err
+	}
+	_, err = s.dst.Write(message[s.envelope:])
+	return err
+}
+
+// Signature returns the signature for the message. The BodyHash and Body
+// are only filled in once the Signer has been closed.
+func (s *Signer) Signature() Signature {
+	return s.sig
+}
+
+// lfWriter writes to w with CRLF line endings converted to LF.
+type lfWriter struct {
+	w  io.Writer
+	cr bool
+}
+
+func (l *lfWriter) Write(p []byte) (int, error) {
+	buf := make([]byte, 0, len(p)+1)
+	for _, c := range p {
+		if l.cr && c != '\n' {
+			buf = append(buf, '\r')
+		}
+		l.cr = c == '\r'
+		if !l.cr {
+			buf = append(buf, c)
+		}
+	}
+	if _, err := l.w.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
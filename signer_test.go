@@ -0,0 +1,231 @@
+package dkim
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSigner(t *testing.T) {
+	var body = testMessage + "\r\n"
+	s, err := NewSignature("relaxed/relaxed", "foo", "example.com", []string{"From", "Subject"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write the message a byte at a time, so that the end of the
+	// headers is split between writes.
+	var signed bytes.Buffer
+	signer, err := NewSigner(s, testKey, &signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(body); i++ {
+		if _, err := signer.Write([]byte{body[i]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if signed.Len() != 0 {
+		t.Error("Signer wrote to destination before it was closed")
+	}
+	if err := signer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(signed.String(), "DKIM-Signature: ") || !strings.HasSuffix(signed.String(), "\r\n"+body) {
+		t.Errorf("Unexpected signed message: %v", signed.String())
+	}
+	if err := VerifyWithPublicKey(bytes.NewReader(signed.Bytes()), &testKey.PublicKey); err != nil {
+		t.Errorf("Could not verify signed message: %v", err)
+	}
+	if _, err := signer.Write([]byte("more")); err == nil {
+		t.Error("Write after Close did not return an error")
+	}
+
+	// Without a destination, the header can be added to the message by
+	// the caller.
+	signer, err = NewSigner(s, testKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := signer.Write([]byte(body)); err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	msg := signer.Signature().String() + "\r\n" + body
+	if err := VerifyWithPublicKey(strings.NewReader(msg), &testKey.PublicKey); err != nil {
+		t.Errorf("Could not verify message with header from Signature: %v", err)
+	}
+}
+
+func TestSignerEnvelope(t *testing.T) {
+	var body = "From test@example.com Wed Jan 24 16:35:04 2018\r\n" + testMessage
+	s, err := NewSignature("simple/simple", "foo", "example.com", []string{"From", "Subject"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var signed bytes.Buffer
+	if err := SignMessage(s, strings.NewReader(body), &signed, testKey, "\n"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(signed.String(), "\n")
+	if len(lines) < 2 || lines[0] != "From test@example.com Wed Jan 24 16:35:04 2018" || !strings.HasPrefix(lines[1], "DKIM-Signature: ") {
+		t.Fatalf("Signature was not added after the mbox From line: %v", signed.String())
+	}
+	if strings.Contains(signed.String(), "\r") {
+		t.Error("Signed message has CRLF line endings")
+	}
+	msg := strings.Replace(strings.Join(lines[1:], "\n"), "\n", "\r\n", -1)
+	if err := VerifyWithPublicKey(strings.NewReader(msg), &testKey.PublicKey); err != nil {
+		t.Errorf("Could not verify signed message: %v", err)
+	}
+}
+
+// wrappedSigner hides the type of the private key behind the
+// crypto.Signer interface, the same way that a key held in an HSM or
+// another process would be.
+type wrappedSigner struct {
+	key   crypto.Signer
+	calls int
+}
+
+func (w *wrappedSigner) Public() crypto.PublicKey {
+	return w.key.Public()
+}
+
+func (w *wrappedSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	w.calls++
+	return w.key.Sign(rand, digest, opts)
+}
+
+func TestCryptoSigner(t *testing.T) {
+	seed, err := base64.StdEncoding.DecodeString(rfc8463PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edkey := ed25519.NewKeyFromSeed(seed)
+	tests := []struct {
+		algorithm string
+		key       crypto.Signer
+		valid     bool
+	}{
+		{"rsa-sha256", testKey, true},
+		{"rsa-sha1", testKey, true},
+		{"ed25519-sha256", edkey, true},
+		// The key type has to match the algorithm.
+		{"ed25519-sha256", testKey, false},
+		{"rsa-sha256", edkey, false},
+	}
+	for i, tc := range tests {
+		s, err := NewSignature("relaxed/relaxed", "foo", "example.com", []string{"From", "Subject"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Algorithm = tc.algorithm
+		signer := &wrappedSigner{key: tc.key}
+		var signed bytes.Buffer
+		err = SignMessage(s, strings.NewReader(testMessage), &signed, signer, "\r\n")
+		if !tc.valid {
+			if err == nil {
+				t.Errorf("Case %d: signed with the wrong type of key", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Case %d: %v", i, err)
+			continue
+		}
+		if signer.calls != 1 {
+			t.Errorf("Case %d: signer was called %d times", i, signer.calls)
+		}
+		v := NewVerifier()
+		v.Algorithms = []string{tc.algorithm}
+		if _, err := v.VerifyResult(bytes.NewReader(signed.Bytes()), tc.key.Public()); err != nil {
+			t.Errorf("Case %d: could not verify signed message: %v", i, err)
+		}
+	}
+}
+
+func TestOversign(t *testing.T) {
+	var body = "From: Test <test@example.com>\r\nSubject: I am a test\r\nTo: Test2 <test2@example.com>\r\n\r\nThis is a test message\r\n"
+	tests := []struct {
+		headers, oversign []string
+		signed            string
+	}{
+		{[]string{"From", "Subject"}, nil, "From:Subject"},
+		{[]string{"From", "Subject"}, []string{"From", "Subject"}, "From:Subject:From:Subject"},
+		// Headers that aren't in the message are signed as absent,
+		// and headers that are already oversigned aren't added again.
+		{[]string{"From", "From", "Subject"}, []string{"from", "Reply-To"}, "From:From:Subject:Reply-To"},
+	}
+	for i, tc := range tests {
+		s, err := NewSignature("relaxed/relaxed", "foo", "example.com", tc.headers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Oversign = tc.oversign
+		signed := signTestMessage(t, s, body)
+		if !strings.Contains(signed, "; h="+tc.signed+";") {
+			t.Errorf("Case %d: did not sign h=%v: %v", i, tc.signed, signed)
+		}
+		if err := VerifyWithPublicKey(strings.NewReader(signed), &testKey.PublicKey); err != nil {
+			t.Errorf("Case %d: could not verify signed message: %v", i, err)
+		}
+
+		// Add another From header above the signature. It's only
+		// covered by the signature if From was oversigned.
+		injected := "From: Attacker <attacker@example.org>\r\n" + signed
+		err = VerifyWithPublicKey(strings.NewReader(injected), &testKey.PublicKey)
+		if len(tc.oversign) == 0 && err != nil {
+			t.Errorf("Case %d: unexpected error: %v", i, err)
+		} else if len(tc.oversign) > 0 && !errors.Is(err, ErrSignatureMismatch) {
+			t.Errorf("Case %d: added From header: got %v want signature mismatch", i, err)
+		}
+	}
+}
+
+func TestHeaderSelection(t *testing.T) {
+	var body = "Return-Path: <test@example.com>\r\nReceived: from mail.example.com\r\nFrom: Test <test@example.com>\r\nTo: Test2 <test2@example.com>\r\nCC: Test3 <test3@example.com>, \r\n  Test4 <test4@example.com>\r\nCc: Test5 <test5@example.com>\r\nSubject: I am a test\r\nX-Mailer: test\r\nList-Id: <test.example.com>\r\n\r\nThis is a test message\r\n"
+	tests := []struct {
+		selection HeaderSelection
+		signed    string
+	}{
+		{HeaderSelection{}, "From:To:Cc:Cc:Subject:List-Id"},
+		{
+			HeaderSelection{Include: []string{"X-Mailer", "Received"}, Exclude: []string{"list-id"}},
+			"From:Received:To:Cc:Cc:Subject:X-Mailer",
+		},
+		// From can't be excluded.
+		{HeaderSelection{Exclude: []string{"From", "To", "Cc"}}, "From:Subject:List-Id"},
+	}
+	for i, tc := range tests {
+		s, err := NewSignature("relaxed/relaxed", "foo", "example.com", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		*s.HeaderSelection = tc.selection
+		signed := signTestMessage(t, s, body)
+		if !strings.Contains(signed, "; h="+tc.signed+";") {
+			t.Errorf("Case %d: did not sign h=%v: %v", i, tc.signed, signed)
+		}
+		if err := VerifyWithPublicKey(strings.NewReader(signed), &testKey.PublicKey); err != nil {
+			t.Errorf("Case %d: could not verify signed message: %v", i, err)
+		}
+	}
+
+	// Selected headers can still be oversigned.
+	s, err := NewSignature("relaxed/relaxed", "foo", "example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Oversign = []string{"From", "Cc"}
+	if signed := signTestMessage(t, s, body); !strings.Contains(signed, "; h=From:To:Cc:Cc:Subject:List-Id:From:Cc;") {
+		t.Errorf("Selected headers were not oversigned: %v", signed)
+	}
+}
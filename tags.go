@@ -0,0 +1,125 @@
+package dkim
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// A Tag is a single tag=value pair from a DKIM tag list, such as a
+// DKIM-Signature header or a key record.
+type Tag struct {
+	Name, Value string
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// trimFWS trims folding whitespace from both ends of s.
+func trimFWS(s []byte) []byte {
+	return bytes.Trim(s, " \t\r\n")
+}
+
+// A tagSpan is the location of a tag's value in a tag list, including any
+// whitespace surrounding it. It extends from just after the "=" to the
+// ";" that ends the tag, or the end of the list.
+type tagSpan struct {
+	start, end int
+}
+
+// parseTagList parses s according to the tag-list grammar of RFC 6376
+// section 3.2. Leading and trailing whitespace is removed from the
+// values, but any whitespace inside of them is preserved.
+func parseTagList(s []byte) ([]Tag, error) {
+	tags, _, err := scanTagList(s)
+	return tags, err
+}
+
+// scanTagList parses s in the same way as parseTagList, but also returns
+// the location of each tag's value in s.
+func scanTagList(s []byte) ([]Tag, []tagSpan, error) {
+	var tags []Tag
+	var spans []tagSpan
+	seen := make(map[string]bool)
+	specs := bytes.Split(s, []byte{';'})
+	offset := 0
+	for i, spec := range specs {
+		specstart := offset
+		offset += len(spec) + 1
+		if len(trimFWS(spec)) == 0 {
+			// A trailing ";" is permitted, but empty tags are not.
+			if i == len(specs)-1 && i != 0 {
+				break
+			}
+			return nil, nil, fmt.Errorf("empty tag")
+		}
+		eq := bytes.IndexByte(spec, '=')
+		if eq < 0 {
+			return nil, nil, fmt.Errorf("tag %q has no value", trimFWS(spec))
+		}
+		name := trimFWS(spec[:eq])
+		if len(name) == 0 || !isAlpha(name[0]) {
+			return nil, nil, fmt.Errorf("invalid tag name %q", name)
+		}
+		for _, c := range name {
+			if !isAlpha(c) && !isDigit(c) && c != '_' {
+				return nil, nil, fmt.Errorf("invalid tag name %q", name)
+			}
+		}
+		value := trimFWS(spec[eq+1:])
+		for j, c := range value {
+			switch {
+			case c == ' ' || c == '\t':
+			case c == '\r':
+				if j+1 >= len(value) || value[j+1] != '\n' {
+					return nil, nil, fmt.Errorf("invalid line break in tag %s", name)
+				}
+			case c == '\n':
+				// Line breaks are only allowed as part of folding
+				// whitespace. Bare LFs are accepted in addition to
+				// CRLF, so that unnormalized headers can be parsed.
+				if j+1 >= len(value) || (value[j+1] != ' ' && value[j+1] != '\t') {
+					return nil, nil, fmt.Errorf("invalid line break in tag %s", name)
+				}
+			case c >= 0x21 && c <= 0x7e:
+			default:
+				return nil, nil, fmt.Errorf("invalid character in tag %s", name)
+			}
+		}
+		if seen[string(name)] {
+			return nil, nil, fmt.Errorf("duplicate tag %s", name)
+		}
+		seen[string(name)] = true
+		tags = append(tags, Tag{string(name), string(value)})
+		spans = append(spans, tagSpan{specstart + eq + 1, specstart + len(spec)})
+	}
+	return tags, spans, nil
+}
+
+// stripSignature returns a copy of the DKIM-Signature header field header
+// with the value of the b= tag, including any whitespace surrounding it,
+// removed as described in RFC 6376 section 3.7. Everything else, including
+// the whitespace around the tag name, is left untouched.
+func stripSignature(header []byte) ([]byte, error) {
+	colon := bytes.IndexByte(header, ':')
+	if colon < 0 {
+		return nil, permFail(ReasonSyntax, "not a DKIM-Signature header")
+	}
+	tags, spans, err := scanTagList(header[colon+1:])
+	if err != nil {
+		return nil, &Error{Reason: ReasonSyntax, Message: "invalid DKIM-Signature", Err: err}
+	}
+	for i, t := range tags {
+		if t.Name == "b" {
+			start, end := colon+1+spans[i].start, colon+1+spans[i].end
+			stripped := make([]byte, 0, len(header)-(end-start))
+			stripped = append(stripped, header[:start]...)
+			return append(stripped, header[end:]...), nil
+		}
+	}
+	return nil, permFail(ReasonSyntax, "DKIM-Signature is missing required tag b=")
+}
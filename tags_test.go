@@ -0,0 +1,99 @@
+package dkim
+
+import (
+	"testing"
+)
+
+func TestParseTagList(t *testing.T) {
+	tests := []struct {
+		list     string
+		expected []Tag
+		valid    bool
+	}{
+		{"a=b", []Tag{{"a", "b"}}, true},
+		// Trailing semicolons and whitespace are allowed
+		{" a = b ; c=d;\r\n ", []Tag{{"a", "b"}, {"c", "d"}}, true},
+		// Folding whitespace inside of a value is preserved
+		{"b=abc\r\n def; x_1=", []Tag{{"b", "abc\r\n def"}, {"x_1", ""}}, true},
+		// Values may contain "="
+		{"bh=abc==", []Tag{{"bh", "abc=="}}, true},
+		// A tag without "=" is an error
+		{"a=b; c", nil, false},
+		// Empty tags are an error
+		{"a=b;; c=d", nil, false},
+		{";", nil, false},
+		// Duplicate tags are an error
+		{"a=b; a=c", nil, false},
+		// Invalid tag names
+		{"1a=b", nil, false},
+		{"a-b=c", nil, false},
+		{"=c", nil, false},
+		// A line break that isn't followed by whitespace isn't
+		// folding whitespace.
+		{"a=b\r\nc", nil, false},
+		// Values can't contain control characters
+		{"a=b\x00c", nil, false},
+	}
+	for i, tc := range tests {
+		got, err := parseTagList([]byte(tc.list))
+		if !tc.valid {
+			if err == nil {
+				t.Errorf("Case %d: invalid tag list %q parsed as %v", i, tc.list, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Case %d: %v", i, err)
+			continue
+		}
+		if len(got) != len(tc.expected) {
+			t.Errorf("Case %d: got %v want %v", i, got, tc.expected)
+			continue
+		}
+		for j := range got {
+			if got[j] != tc.expected[j] {
+				t.Errorf("Case %d, tag %d: got %q want %q", i, j, got[j], tc.expected[j])
+			}
+		}
+	}
+}
+
+func TestStripSignature(t *testing.T) {
+	tests := []struct {
+		header, expected string
+	}{
+		{
+			"dkim-signature:v=1; a=rsa-sha256; bh=abc=; b=def=",
+			"dkim-signature:v=1; a=rsa-sha256; bh=abc=; b=",
+		},
+		// Whitespace around the value is removed, but not the
+		// whitespace around the tag name.
+		{
+			"DKIM-Signature: v=1; b = def= ; bh=abc=",
+			"DKIM-Signature: v=1; b =; bh=abc=",
+		},
+		// Folded values are entirely removed
+		{
+			"DKIM-Signature: v=1; bh=abc=;\r\n b=de\r\n  f=\r\n\t gh==",
+			"DKIM-Signature: v=1; bh=abc=;\r\n b=",
+		},
+		// b= inside of another tag's value is left alone
+		{
+			"DKIM-Signature: v=1; foo=ab=cd; b=def; z=From:b=3Dx",
+			"DKIM-Signature: v=1; foo=ab=cd; b=; z=From:b=3Dx",
+		},
+	}
+	for i, tc := range tests {
+		got, err := stripSignature([]byte(tc.header))
+		if err != nil {
+			t.Errorf("Case %d: %v", i, err)
+			continue
+		}
+		if string(got) != tc.expected {
+			t.Errorf("Case %d: got %q want %q", i, got, tc.expected)
+		}
+	}
+	if _, err := stripSignature([]byte("DKIM-Signature: v=1; bh=abc=")); err == nil {
+		t.Error("Signature without b= was stripped")
+	}
+}
@@ -0,0 +1,413 @@
+package dkim
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// DefaultClockSkew is the amount of time that a signature's timestamp may
+// be in the future before it's rejected by a Verifier returned from
+// NewVerifier.
+const DefaultClockSkew = 5 * time.Minute
+
+// DefaultMinRSAKeyBits is the smallest RSA key that a Verifier accepts if
+// its MinRSAKeyBits is 0. RFC 8301 requires verifiers to reject shorter
+// keys.
+const DefaultMinRSAKeyBits = 1024
+
+// DefaultAlgorithms are the signing algorithms that a Verifier accepts if
+// its Algorithms is nil. rsa-sha1 is not included, since RFC 8301 requires
+// verifiers to reject it.
+var DefaultAlgorithms = []string{"rsa-sha256", "ed25519-sha256"}
+
+// DefaultRequiredHeaders are the headers that a signature must cover to be
+// accepted by a Verifier if its RequiredHeaders is nil. Signatures which
+// don't cover one have a result of Policy, except for From: RFC 6376
+// requires it to be signed, so a signature without it is malformed and
+// has a result of PermError whatever the Verifier requires.
+var DefaultRequiredHeaders = []string{"From"}
+
+// A Verifier verifies DKIM signatures on messages.
+//
+// The zero value is ready to use, but does not allow for any clock skew.
+//
+// Signatures which use an algorithm or key that isn't allowed by the
+// Verifier's policy have a result of Policy, whether or not they would
+// otherwise verify.
+type Verifier struct {
+	// Now returns the current time. If nil, time.Now is used. It can be
+	// replaced to make time dependent results reproducible.
+	Now func() time.Time
+
+	// ClockSkew is the amount of time that a signature's timestamp may
+	// be in the future, or that a signature may be past its expiration,
+	// before it's rejected.
+	ClockSkew time.Duration
+
+	// Resolver is used to look up public keys from the DNS. If nil,
+	// net.DefaultResolver is used.
+	Resolver Resolver
+
+	// Cache caches the public keys looked up with Resolver. If nil,
+	// every signature looks up its key.
+	Cache *KeyCache
+
+	// MinRSAKeyBits is the size in bits of the shortest RSA key that
+	// signatures are accepted from. If 0, DefaultMinRSAKeyBits is
+	// used.
+	MinRSAKeyBits int
+
+	// Algorithms lists the signing algorithms (the a= tag) that are
+	// accepted. If nil, DefaultAlgorithms is used.
+	Algorithms []string
+
+	// RequiredHeaders lists the headers that a signature must cover
+	// (in the h= tag) to be accepted, such as Subject or To. If nil,
+	// DefaultRequiredHeaders is used. A signature that doesn't cover
+	// From is always a PermError, as required by RFC 6376.
+	RequiredHeaders []string
+
+	// MaxHeaderLength is the maximum length in bytes of a single header
+	// field in a message. If 0, DefaultMaxHeaderLength is used.
+	MaxHeaderLength int
+}
+
+// NewVerifier returns a Verifier with the default settings.
+func NewVerifier() *Verifier {
+	return &Verifier{ClockSkew: DefaultClockSkew}
+}
+
+// Status is the outcome of verifying a single DKIM signature. The
+// String values match the dkim method results of RFC 8601.
+type Status int
+
+const (
+	// The signature was verified.
+	Pass Status = iota
+	// The signature could be processed, but did not verify.
+	Fail
+	// The signature could not be verified because of a temporary
+	// error, such as a DNS timeout.
+	TempError
+	// The signature could not be processed, for instance because it
+	// was malformed or the public key could not be found.
+	PermError
+	// The signature is not acceptable to the verifier's policy, for
+	// instance because it uses a weak algorithm or key.
+	Policy
+)
+
+func (s Status) String() string {
+	switch s {
+	case Pass:
+		return "pass"
+	case Fail:
+		return "fail"
+	case TempError:
+		return "temperror"
+	case PermError:
+		return "permerror"
+	case Policy:
+		return "policy"
+	}
+	return "unknown"
+}
+
+// Result contains details about a verified DKIM signature.
+type Result struct {
+	// The signature which was verified. It is nil if the signature
+	// could not be parsed.
+	Signature *Signature
+
+	// The signing domain, selector and algorithm from the signature.
+	Domain, Selector, Algorithm string
+
+	// The outcome of verifying the signature, and the reason for it
+	// if it did not pass.
+	Status Status
+	Err    error
+
+	// UnsignedBodyBytes is the number of bytes of the canonicalized
+	// body that came after the signature's length limit and were not
+	// covered by the signature.
+	UnsignedBodyBytes int64
+
+	// Testing is true if the key record is in testing mode (t=y). The
+	// result is not authoritative, and the message should be treated
+	// the same as an unsigned message.
+	Testing bool
+}
+
+func (v *Verifier) now() time.Time {
+	if v.Now == nil {
+		return time.Now()
+	}
+	return v.Now()
+}
+
+// lookupKey looks up the key record at loc, from the cache if v has one.
+func (v *Verifier) lookupKey(ctx context.Context, loc string) (*KeyRecord, error) {
+	if v.Cache != nil {
+		return v.Cache.lookup(ctx, v.Resolver, loc)
+	}
+	return lookupKey(ctx, v.Resolver, loc)
+}
+
+// checkAlgorithm ensures that the algorithm of sig is allowed by v.
+func (v *Verifier) checkAlgorithm(sig *Signature) error {
+	// Algorithms that aren't supported at all are a permanent error
+	// rather than a policy one.
+	if _, err := hashAlgorithm(sig.Algorithm); err != nil {
+		return err
+	}
+	algorithms := v.Algorithms
+	if algorithms == nil {
+		algorithms = DefaultAlgorithms
+	}
+	for _, a := range algorithms {
+		if a == sig.Algorithm {
+			return nil
+		}
+	}
+	return permFail(ReasonPolicy, "algorithm %v not allowed", sig.Algorithm)
+}
+
+// checkHeaders ensures that sig covers the headers required by v.
+func (v *Verifier) checkHeaders(sig *Signature) error {
+	required := v.RequiredHeaders
+	if required == nil {
+		required = DefaultRequiredHeaders
+	}
+	for _, r := range required {
+		signed := false
+		for _, h := range sig.Headers {
+			if strings.EqualFold(h, r) {
+				signed = true
+				break
+			}
+		}
+		if !signed {
+			return permFail(ReasonPolicy, "required header %v not signed", r)
+		}
+	}
+	return nil
+}
+
+// checkKey ensures that key is strong enough to be allowed by v.
+func (v *Verifier) checkKey(key crypto.PublicKey) error {
+	rsakey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil
+	}
+	min := v.MinRSAKeyBits
+	if min == 0 {
+		min = DefaultMinRSAKeyBits
+	}
+	if bits := rsakey.N.BitLen(); bits < min {
+		return permFail(ReasonPolicy, "RSA key is %d bits, less than the minimum of %d", bits, min)
+	}
+	return nil
+}
+
+// checkTimes ensures that the current time is within the validity period
+// of sig.
+func (v *Verifier) checkTimes(sig *Signature) error {
+	now := v.now()
+	if !sig.Timestamp.IsZero() && sig.Timestamp.After(now.Add(v.ClockSkew)) {
+		return ErrFutureTimestamp
+	}
+	if !sig.Expiration.IsZero() && now.After(sig.Expiration.Add(v.ClockSkew)) {
+		return ErrExpired
+	}
+	return nil
+}
+
+// VerifyResult verifies the message from reader r, returning details
+// about the signature that was verified. If key is nil, the public key
+// is looked up from the DNS. key may also be a *KeyRecord, in which case
+// the restrictions of the record are enforced.
+//
+// If the message has more than one DKIM-Signature, the first one that
+// passes is returned. If none pass, the result for the first signature
+// in the message is returned along with its error.
+//
+// Newlines in r must already be in CRLF format.
+func (v *Verifier) VerifyResult(r io.Reader, key crypto.PublicKey) (Result, error) {
+	return v.VerifyResultContext(context.Background(), r, key)
+}
+
+// VerifyResultContext is like VerifyResult, but ctx bounds any DNS
+// lookups for public keys.
+func (v *Verifier) VerifyResultContext(ctx context.Context, r io.Reader, key crypto.PublicKey) (Result, error) {
+	results, err := v.VerifyAllContext(ctx, r, key)
+	if err != nil {
+		return Result{}, err
+	}
+	for _, res := range results {
+		if res.Status == Pass {
+			return res, nil
+		}
+	}
+	return results[0], results[0].Err
+}
+
+// VerifyAll verifies every DKIM-Signature in the message from reader r
+// independently, and returns a result for each of them in the order they
+// appear in the message. If key is nil, the public keys are looked up
+// from the DNS. key may also be a *KeyRecord, in which case the
+// restrictions of the record are enforced.
+//
+// The message is read in a single pass. Only the headers are kept in
+// memory, and the body is hashed for each signature as it's read, so r
+// does not need to be seekable and messages of any size can be verified.
+//
+// An error is returned only if the message could not be read or has no
+// DKIM signatures.
+//
+// Newlines in r must already be in CRLF format.
+func (v *Verifier) VerifyAll(r io.Reader, key crypto.PublicKey) ([]Result, error) {
+	return v.VerifyAllContext(context.Background(), r, key)
+}
+
+// VerifyAllContext is like VerifyAll, but ctx bounds any DNS lookups for
+// public keys. If ctx is done before a key is found, the signature has a
+// TempError result.
+func (v *Verifier) VerifyAllContext(ctx context.Context, r io.Reader, key crypto.PublicKey) ([]Result, error) {
+	br := bufio.NewReader(r)
+	headers, err := readBufferedHeaders(br, v.MaxHeaderLength)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start a body hash for every signature that gets far enough to
+	// need one, so that the body only needs to be read once.
+	var results []Result
+	var sigheaders []Header
+	var hashers []*bodyHasher
+	var writers []io.Writer
+	for _, h := range headers {
+		if h.name() != "dkim-signature" {
+			continue
+		}
+		res, body := v.startSignature(h)
+		results = append(results, res)
+		sigheaders = append(sigheaders, h)
+		hashers = append(hashers, body)
+		if body != nil {
+			writers = append(writers, body)
+		}
+	}
+	if len(results) == 0 {
+		return nil, ErrNoSignature
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), br); err != nil {
+		return nil, err
+	}
+	for i := range results {
+		if hashers[i] == nil {
+			continue
+		}
+		results[i].Err = v.verifyParsed(ctx, &results[i], headers, hashers[i], sigheaders[i], key)
+		results[i].Status = statusFor(results[i].Err)
+	}
+	return results, nil
+}
+
+// statusFor returns the Status of a signature which failed to verify with
+// the error err.
+func statusFor(err error) Status {
+	switch {
+	case err == nil:
+		return Pass
+	case errors.Is(err, ErrTempFail):
+		return TempError
+	case errors.Is(err, ErrBodyHashMismatch), errors.Is(err, ErrSignatureMismatch):
+		return Fail
+	case errors.Is(err, ErrPolicy):
+		return Policy
+	}
+	return PermError
+}
+
+// startSignature parses the DKIM-Signature header dkimheader and checks
+// the parts of it that don't depend on the message. It returns a
+// bodyHasher for the signature if it should go on to be verified, or a
+// nil one if res is already the final result.
+func (v *Verifier) startSignature(dkimheader Header) (res Result, body *bodyHasher) {
+	sig, err := ParseSignature(dkimheader.Raw)
+	if err != nil {
+		return Result{Status: statusFor(err), Err: err}, nil
+	}
+	res = Result{
+		Signature: sig,
+		Domain:    sig.Domain,
+		Selector:  sig.Selector,
+		Algorithm: sig.Algorithm,
+	}
+	err = sig.checkIdentity()
+	if err == nil {
+		err = v.checkAlgorithm(sig)
+	}
+	if err == nil {
+		err = v.checkHeaders(sig)
+	}
+	if err == nil {
+		err = v.checkTimes(sig)
+	}
+	if err == nil {
+		body, err = newBodyHasher(sig)
+	}
+	if err != nil {
+		res.Err, res.Status = err, statusFor(err)
+		return res, nil
+	}
+	return res, body
+}
+
+// verifyParsed finishes verifying the signature in res.Signature from the
+// DKIM-Signature header dkimheader once the body has been written to
+// body, filling in the rest of res as it goes.
+func (v *Verifier) verifyParsed(ctx context.Context, res *Result, headers []Header, body *bodyHasher, dkimheader Header, key crypto.PublicKey) error {
+	sig := res.Signature
+	msg, sighead, unsigned, err := verifyBase(headers, body, sig, dkimheader)
+	res.UnsignedBodyBytes = unsigned
+	if err != nil {
+		return err
+	}
+	// key may be a full key record, in which case its restrictions are
+	// enforced, or a bare public key.  A nil *KeyRecord is treated the
+	// same as no key, and the key is looked up in DNS.
+	rec, isrecord := key.(*KeyRecord)
+	if key == nil || (isrecord && rec == nil) {
+		if rec, err = v.lookupKey(ctx, sig.Selector+"._domainkey."+sig.Domain); err != nil {
+			return err
+		}
+		isrecord = true
+	}
+	if isrecord {
+		if rec.PublicKey == nil {
+			return ErrKeyRevoked
+		}
+		if err := rec.check(sig); err != nil {
+			return err
+		}
+	} else {
+		rec = &KeyRecord{PublicKey: key}
+	}
+	res.Testing = rec.Testing()
+	if err := v.checkKey(rec.PublicKey); err != nil {
+		return err
+	}
+	sighash, err := base64.StdEncoding.DecodeString(sig.Body)
+	if err != nil {
+		return permFail(ReasonSyntax, "could not decode signature")
+	}
+	return dkimVerify(msg, sighead, sighash, sig.Algorithm, rec.PublicKey)
+}